@@ -72,6 +72,10 @@ function! s:validHighlightReferences(v)
     return s:validBool(a:v)
 endfunction
 
+function! s:validHighlightSemanticTokens(v)
+    return s:validBool(a:v)
+endfunction
+
 function! s:validHoverDiagnostics(v)
     return s:validBool(a:v)
 endfunction
@@ -80,6 +84,38 @@ function! s:validCompletionDeepCompletions(v)
   return s:validBool(a:v)
 endfunction
 
+function! s:validCompletionSnippets(v)
+  return s:validBool(a:v)
+endfunction
+
+function! s:validCompletionAsync(v)
+  return s:validBool(a:v)
+endfunction
+
+function! s:validCompletionLazyDocumentation(v)
+  return s:validBool(a:v)
+endfunction
+
+function! s:validCompletionKindIcons(v)
+  let valid = ["function", "method", "variable", "field", "type", "constant", "package"]
+  if type(a:v) != 4
+    return [v:false, "value must be a dict"]
+  endif
+  for [key, value] in items(a:v)
+    if index(valid, key) < 0
+      return [v:false, "key ".key." must be one of: ".string(valid)]
+    endif
+    if type(value) != 1
+      return [v:false, "value for key ".key." must be a string"]
+    endif
+  endfor
+  return [v:true, ""]
+endfunction
+
+function! s:validSignatureHelpAuto(v)
+  return s:validBool(a:v)
+endfunction
+
 function! s:validCompletionMatcher(v)
   let valid = ["caseInsensitive", "caseSensitive", "fuzzy"]
   if index(valid, a:v) < 0
@@ -148,6 +184,36 @@ function! s:validGoplsDirectoryFilters(v)
   return [v:true, ""]
 endfunction
 
+function! s:validExtraBuildConfigs(v)
+  if type(a:v) != 3
+    return [v:false, "value must be a list"]
+  endif
+  for item in a:v
+    if type(item) != 4
+      return [v:false, "value must be a list of dicts"]
+    endif
+    for [key, value] in items(item)
+      if key == "GOOS" || key == "GOARCH"
+        if type(value) != 1
+          return [v:false, "value for key ".key." must be a string"]
+        endif
+      elseif key == "Tags"
+        if type(value) != 3
+          return [v:false, "value for key Tags must be a list"]
+        endif
+        for tag in value
+          if type(tag) != 1
+            return [v:false, "value for key Tags must be a list of strings"]
+          endif
+        endfor
+      else
+        return [v:false, "unknown key ".key]
+      endif
+    endfor
+  endfor
+  return [v:true, ""]
+endfunction
+
 function! s:validAnalyses(v)
   if type(a:v) != 4
     return [v:false, "must be of type dict"]
@@ -160,10 +226,30 @@ function! s:validAnalyses(v)
   return [v:true, ""]
 endfunction
 
+function! s:validInlayHints(v)
+  return s:validAnalyses(a:v)
+endfunction
+
+function! s:validFolding(v)
+  return s:validBool(a:v)
+endfunction
+
 function! s:openLastProgressWith(v)
   return s:validString(a:v)
 endfunction
 
+function! s:validOpenExternalWith(v)
+  return s:validString(a:v)
+endfunction
+
+function! s:validGoplsRemote(v)
+  return s:validString(a:v)
+endfunction
+
+function! s:validVulncheckDB(v)
+  return s:validString(a:v)
+endfunction
+
 function! s:validGofumpt(v)
   return s:validBool(a:v)
 endfunction
@@ -219,11 +305,17 @@ let s:validators = {
       \ "QuickfixAutoDiagnostics": function("s:validQuickfixAutoDiagnostics"),
       \ "CompletionDeepCompletions": function("s:validCompletionDeepCompletions"),
       \ "CompletionMatcher": function("s:validCompletionMatcher"),
+      \ "CompletionSnippets": function("s:validCompletionSnippets"),
+      \ "CompletionAsync": function("s:validCompletionAsync"),
+      \ "CompletionLazyDocumentation": function("s:validCompletionLazyDocumentation"),
+      \ "CompletionKindIcons": function("s:validCompletionKindIcons"),
+      \ "SignatureHelpAuto": function("s:validSignatureHelpAuto"),
       \ "SymbolMatcher": function("s:validSymbolMatcher"),
       \ "SymbolStyle": function("s:validSymbolStyle"),
       \ "QuickfixSigns": function("s:validQuickfixSigns"),
       \ "HighlightDiagnostics": function("s:validHighlightDiagnostics"),
       \ "HighlightReferences": function("s:validHighlightReferences"),
+      \ "HighlightSemanticTokens": function("s:validHighlightSemanticTokens"),
       \ "HoverDiagnostics": function("s:validHoverDiagnostics"),
       \ "Staticcheck": function("s:validStaticcheck"),
       \ "CompleteUnimported": function("s:validCompleteUnimported"),
@@ -232,8 +324,14 @@ let s:validators = {
       \ "TempModfile": function("s:validTempModfile"),
       \ "GoplsEnv": function("s:validGoplsEnv"),
       \ "GoplsDirectoryFilters": function("s:validGoplsDirectoryFilters"),
+      \ "ExtraBuildConfigs": function("s:validExtraBuildConfigs"),
       \ "Analyses": function("s:validAnalyses"),
+      \ "InlayHints": function("s:validInlayHints"),
+      \ "Folding": function("s:validFolding"),
       \ "OpenLastProgressWith": function("s:openLastProgressWith"),
+      \ "OpenExternalWith": function("s:validOpenExternalWith"),
+      \ "GoplsRemote": function("s:validGoplsRemote"),
+      \ "VulncheckDB": function("s:validVulncheckDB"),
       \ "Gofumpt": function("s:validGofumpt"),
       \ "ExperimentalAutoreadLoadedBuffers": function("s:validExperimentalAutoreadLoadedBuffers"),
       \ "ExperimentalMouseTriggeredHoverPopupOptions": function("s:validExperimentalMouseTriggeredHoverPopupOptions"),
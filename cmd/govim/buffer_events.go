@@ -65,6 +65,7 @@ func (v *vimstate) addBuffer(nb *types.Buffer) error {
 
 	v.buffers[nb.Num] = nb
 	nb.Version = 1
+	v.addWorkspaceFolderFor(nb)
 	nb.Listener = v.ParseInt(v.ChannelCall("listener_add", v.Prefix()+string(config.FunctionEnrichDelta), nb.Num))
 
 	if err := v.updateSigns(true); err != nil {
@@ -75,6 +76,16 @@ func (v *vimstate) addBuffer(nb *types.Buffer) error {
 		v.Logf("failed to update highlights for buffer %d: %v", nb.Num, err)
 	}
 
+	if len(v.vulns) > 0 {
+		v.annotateVulnerableRequires(nb)
+	}
+
+	if signs := v.testSigns(nb); len(signs) > 0 {
+		v.ChannelCall("sign_placelist", signs)
+	}
+
+	v.showCoverage(nb)
+
 	return v.handleBufferEvent(nb)
 }
 
@@ -167,9 +178,17 @@ func (v *vimstate) bufChanged(args ...json.RawMessage) (interface{}, error) {
 	// add back trailing newline
 	b.SetContents(append(bytes.Join(contents, []byte("\n")), '\n'))
 	v.triggerBufferASTUpdate(b)
+	v.invalidateCoverage(b, changes)
 	if err := v.server.DidChange(context.Background(), params); err != nil {
 		return nil, fmt.Errorf("failed to notify gopls of change: %v", err)
 	}
+	if err := v.updateInlayHints(); err != nil {
+		return nil, fmt.Errorf("failed to update inlay hints: %v", err)
+	}
+	if err := v.updateSemanticTokens(); err != nil {
+		return nil, fmt.Errorf("failed to update semantic tokens: %v", err)
+	}
+	v.updateFolds(b)
 	return nil, nil
 }
 
@@ -208,8 +227,11 @@ func (v *vimstate) handleBufferEvent(b *types.Buffer) error {
 				Text:       string(b.Contents()),
 			},
 		}
-		err := v.server.DidOpen(context.Background(), params)
-		return err
+		if err := v.server.DidOpen(context.Background(), params); err != nil {
+			return err
+		}
+		v.updateFolds(b)
+		return nil
 	}
 
 	params := &protocol.DidChangeTextDocumentParams{
@@ -223,8 +245,11 @@ func (v *vimstate) handleBufferEvent(b *types.Buffer) error {
 			},
 		},
 	}
-	err := v.server.DidChange(context.Background(), params)
-	return err
+	if err := v.server.DidChange(context.Background(), params); err != nil {
+		return err
+	}
+	v.updateFolds(b)
+	return nil
 }
 
 func (v *vimstate) bufDelete(args ...json.RawMessage) error {
@@ -264,12 +289,20 @@ func (v *vimstate) deleteBuffer(b *types.Buffer) error {
 
 	v.ChannelCall("listener_remove", b.Listener)
 	delete(v.buffers, b.Num)
+	delete(v.semanticTokens, b.Num)
+	delete(v.folds, b.Num)
+	for winid, s := range v.selectionStacks {
+		if s.buf == b {
+			delete(v.selectionStacks, winid)
+		}
+	}
 	params := &protocol.DidCloseTextDocumentParams{
 		TextDocument: b.ToTextDocumentIdentifier(),
 	}
 	if err := v.server.DidClose(context.Background(), params); err != nil {
 		return fmt.Errorf("failed to call gopls.DidClose on %v: %v", b.Name, err)
 	}
+	v.removeUnusedWorkspaceFolders()
 	return nil
 }
 
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/fakenet"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/jsonrpc2"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/span"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/kr/pretty"
+)
+
+const goplsBuildFlags = "buildFlags"
+
+// buildConfigGopls is an additional gopls instance that diagnoses code under
+// one of config.Config.ExtraBuildConfigs. It is kept in sync with Vim by
+// buildConfigsServer, and is otherwise only used for its diagnostics.
+type buildConfigGopls struct {
+	g      *govimplugin
+	config config.BuildConfig
+	name   string
+
+	process *os.Process
+	stdin   io.Closer
+	server  protocol.Server
+	cancel  context.CancelFunc
+
+	// stopped is closed when we stop the instance, exited when its process
+	// exits.
+	stopped chan struct{}
+	exited  chan struct{}
+
+	// diagnostics holds the diagnostics last published by the instance. It is
+	// protected by g.diagnosticsChangedLock.
+	diagnostics map[span.URI]*protocol.PublishDiagnosticsParams
+}
+
+var _ protocol.Client = (*buildConfigGopls)(nil)
+
+// logPrefixUnsafe matches the characters of a build config name that we do
+// not want in the name of its gopls log file.
+var logPrefixUnsafe = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// startBuildConfigGopls starts and initialises a gopls instance for each of
+// configs, the extra build configurations.
+func (g *govimplugin) startBuildConfigGopls(configs []config.BuildConfig) error {
+	for _, bc := range configs {
+		b, err := g.newBuildConfigGopls(bc)
+		if err != nil {
+			return fmt.Errorf("failed to start gopls for build config %v: %v", bc, err)
+		}
+		g.buildConfigs = append(g.buildConfigs, b)
+	}
+	return nil
+}
+
+func (g *govimplugin) newBuildConfigGopls(bc config.BuildConfig) (*buildConfigGopls, error) {
+	b := &buildConfigGopls{
+		g:           g,
+		config:      bc,
+		name:        bc.String(),
+		stopped:     make(chan struct{}),
+		exited:      make(chan struct{}),
+		diagnostics: make(map[span.URI]*protocol.PublishDiagnosticsParams),
+	}
+	logPrefix := "gopls-" + strings.Trim(logPrefixUnsafe.ReplaceAllString(b.name, "_"), "_")
+	gopls, logfile, err := g.goplsCommand(logPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if logfile != "" {
+		g.Logf("gopls log file for build config %v: %v", b.name, logfile)
+	}
+	g.Logf("Running gopls for build config %v: %v", b.name, strings.Join(gopls.Args, " "))
+	stderr, err := gopls.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe for gopls: %v", err)
+	}
+	g.tomb.Go(func() error {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			g.Logf("gopls (%v) stderr: %v", b.name, scanner.Text())
+		}
+		return nil
+	})
+	stdout, err := gopls.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe for gopls: %v", err)
+	}
+	stdin, err := gopls.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe for gopls: %v", err)
+	}
+	if err := gopls.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start gopls: %v", err)
+	}
+	g.tomb.Go(func() error {
+		err := gopls.Wait()
+		close(b.exited)
+		select {
+		case <-g.inShutdown:
+			return nil
+		case <-b.stopped:
+			return nil
+		default:
+		}
+		// The main gopls instance is what matters; we simply stop reporting
+		// diagnostics for this build config until gopls is next restarted.
+		g.Logf("gopls for build config %v exited unexpectedly: %v", b.name, err)
+		g.Schedule(func(govim.Govim) error {
+			b.clearDiagnostics()
+			return g.vimstate.handleDiagnosticsChanged()
+		})
+		return nil
+	})
+
+	fakeconn := fakenet.NewConn("stdio", stdout, stdin)
+	stream := jsonrpc2.NewHeaderStream(fakeconn)
+	ctxt, cancel := context.WithCancel(context.Background())
+	conn := jsonrpc2.NewConn(stream)
+	handler := protocol.ClientHandler(b, jsonrpc2.MethodNotFound)
+	handler = protocol.Handlers(handler)
+	ctxt = protocol.WithClient(ctxt, b)
+	conn.Go(ctxt, handler)
+
+	b.process = gopls.Process
+	b.stdin = stdin
+	b.server = protocol.ServerDispatcher(conn)
+	b.cancel = cancel
+
+	initParams := &protocol.ParamInitialize{}
+	initParams.WorkspaceFolders = g.workspaceFolderList()
+	initParams.Capabilities.Workspace.Configuration = true
+	initParams.Capabilities.Workspace.WorkspaceFolders = true
+	initParams.Capabilities.Workspace.DidChangeConfiguration.DynamicRegistration = true
+	if _, err := b.server.Initialize(context.Background(), initParams); err != nil {
+		b.stop()
+		return nil, fmt.Errorf("failed to initialise gopls: %v", err)
+	}
+	if err := b.server.Initialized(context.Background(), &protocol.InitializedParams{}); err != nil {
+		b.stop()
+		return nil, fmt.Errorf("failed to call gopls.Initialized: %v", err)
+	}
+	return b, nil
+}
+
+// stopBuildConfigGopls stops the gopls instances for the extra build
+// configurations, forgetting their diagnostics.
+func (g *govimplugin) stopBuildConfigGopls() {
+	for _, b := range g.buildConfigs {
+		b.stop()
+		b.clearDiagnostics()
+	}
+	g.buildConfigs = nil
+}
+
+// stop stops the gopls instance, if it has not already exited.
+func (b *buildConfigGopls) stop() {
+	select {
+	case <-b.stopped:
+		return
+	default:
+	}
+	close(b.stopped)
+	select {
+	case <-b.exited:
+	default:
+		ctxt, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		if err := b.server.Shutdown(ctxt); err != nil {
+			b.g.Logf("failed to call Shutdown on gopls for build config %v: %v", b.name, err)
+		}
+		cancel()
+	}
+	if err := b.stdin.Close(); err != nil {
+		b.g.Logf("failed to close stdin of gopls for build config %v: %v", b.name, err)
+	}
+	select {
+	case <-b.exited:
+	case <-time.After(time.Second):
+		b.g.Logf("gopls for build config %v did not exit; killing it", b.name)
+		b.process.Kill()
+	}
+	b.cancel()
+}
+
+func (b *buildConfigGopls) clearDiagnostics() {
+	b.g.diagnosticsChangedLock.Lock()
+	b.diagnostics = make(map[span.URI]*protocol.PublishDiagnosticsParams)
+	b.g.diagnosticsChanged = true
+	b.g.diagnosticsChangedLock.Unlock()
+}
+
+// buildConfigsServer is the protocol.Server used when there are extra build
+// configurations. It forwards the notifications that keep gopls in sync with
+// Vim to the gopls instance for each build configuration, as well as the main
+// instance. Errors from the former are only logged.
+type buildConfigsServer struct {
+	protocol.Server
+	g *govimplugin
+}
+
+func (s buildConfigsServer) forEach(method string, f func(protocol.Server) error) {
+	for _, b := range s.g.buildConfigs {
+		select {
+		case <-b.exited:
+			continue
+		default:
+		}
+		if err := f(b.server); err != nil {
+			s.g.Logf("failed to call %v on gopls for build config %v: %v", method, b.name, err)
+		}
+	}
+}
+
+func (s buildConfigsServer) DidOpen(ctxt context.Context, params *protocol.DidOpenTextDocumentParams) error {
+	err := s.Server.DidOpen(ctxt, params)
+	s.forEach("DidOpen", func(server protocol.Server) error {
+		return server.DidOpen(ctxt, params)
+	})
+	return err
+}
+
+func (s buildConfigsServer) DidChange(ctxt context.Context, params *protocol.DidChangeTextDocumentParams) error {
+	err := s.Server.DidChange(ctxt, params)
+	s.forEach("DidChange", func(server protocol.Server) error {
+		return server.DidChange(ctxt, params)
+	})
+	return err
+}
+
+func (s buildConfigsServer) DidClose(ctxt context.Context, params *protocol.DidCloseTextDocumentParams) error {
+	err := s.Server.DidClose(ctxt, params)
+	s.forEach("DidClose", func(server protocol.Server) error {
+		return server.DidClose(ctxt, params)
+	})
+	return err
+}
+
+func (s buildConfigsServer) DidSave(ctxt context.Context, params *protocol.DidSaveTextDocumentParams) error {
+	err := s.Server.DidSave(ctxt, params)
+	s.forEach("DidSave", func(server protocol.Server) error {
+		return server.DidSave(ctxt, params)
+	})
+	return err
+}
+
+func (s buildConfigsServer) DidChangeWatchedFiles(ctxt context.Context, params *protocol.DidChangeWatchedFilesParams) error {
+	err := s.Server.DidChangeWatchedFiles(ctxt, params)
+	s.forEach("DidChangeWatchedFiles", func(server protocol.Server) error {
+		return server.DidChangeWatchedFiles(ctxt, params)
+	})
+	return err
+}
+
+func (s buildConfigsServer) DidChangeWorkspaceFolders(ctxt context.Context, params *protocol.DidChangeWorkspaceFoldersParams) error {
+	err := s.Server.DidChangeWorkspaceFolders(ctxt, params)
+	s.forEach("DidChangeWorkspaceFolders", func(server protocol.Server) error {
+		return server.DidChangeWorkspaceFolders(ctxt, params)
+	})
+	return err
+}
+
+func (s buildConfigsServer) DidChangeConfiguration(ctxt context.Context, params *protocol.DidChangeConfigurationParams) error {
+	err := s.Server.DidChangeConfiguration(ctxt, params)
+	s.forEach("DidChangeConfiguration", func(server protocol.Server) error {
+		return server.DidChangeConfiguration(ctxt, params)
+	})
+	return err
+}
+
+func (b *buildConfigGopls) logf(format string, args ...interface{}) {
+	b.g.logGoplsClientf("(%v) "+format, append([]interface{}{b.name}, args...)...)
+}
+
+func (b *buildConfigGopls) PublishDiagnostics(ctxt context.Context, params *protocol.PublishDiagnosticsParams) error {
+	defer absorbShutdownErr()
+	b.logf("PublishDiagnostics callback: %v", pretty.Sprint(params))
+	g := b.g
+	g.diagnosticsChangedLock.Lock()
+	uri := span.URI(params.URI)
+	curr, ok := b.diagnostics[uri]
+	b.diagnostics[uri] = params
+	g.diagnosticsChanged = true
+	g.diagnosticsChangedLock.Unlock()
+	if !ok {
+		if len(params.Diagnostics) == 0 {
+			return nil
+		}
+	} else if reflect.DeepEqual(curr, params) {
+		return nil
+	}
+
+	g.Schedule(func(govim.Govim) error {
+		v := g.vimstate
+		if v.userBusy {
+			return nil
+		}
+		return v.handleDiagnosticsChanged()
+	})
+	return nil
+}
+
+func (b *buildConfigGopls) Configuration(ctxt context.Context, params *protocol.ParamConfiguration) ([]interface{}, error) {
+	defer absorbShutdownErr()
+	b.logf("Configuration: %v", pretty.Sprint(params))
+	if len(params.Items) == 0 || params.Items[0].Section != "gopls" {
+		return nil, fmt.Errorf("govim gopls client: expected at least one item, with the first section \"gopls\"")
+	}
+	res := make([]interface{}, len(params.Items))
+	goplsConfig := b.g.goplsConfiguration()
+	env := make(map[string]string)
+	if e, ok := goplsConfig[goplsEnv].(map[string]string); ok {
+		for k, v := range e {
+			env[k] = v
+		}
+	}
+	if b.config.GOOS != "" {
+		env["GOOS"] = b.config.GOOS
+	}
+	if b.config.GOARCH != "" {
+		env["GOARCH"] = b.config.GOARCH
+	}
+	goplsConfig[goplsEnv] = env
+	if len(b.config.Tags) > 0 {
+		goplsConfig[goplsBuildFlags] = []string{"-tags=" + strings.Join(b.config.Tags, ",")}
+	}
+	res[0] = goplsConfig
+	b.logf("Configuration response: %v", pretty.Sprint(res))
+	return res, nil
+}
+
+func (b *buildConfigGopls) WorkspaceFolders(context.Context) ([]protocol.WorkspaceFolder, error) {
+	defer absorbShutdownErr()
+	return b.g.workspaceFolderList(), nil
+}
+
+func (b *buildConfigGopls) LogMessage(ctxt context.Context, params *protocol.LogMessageParams) error {
+	defer absorbShutdownErr()
+	b.logf("LogMessage callback: %v", pretty.Sprint(params))
+	return nil
+}
+
+func (b *buildConfigGopls) ShowMessage(ctxt context.Context, params *protocol.ShowMessageParams) error {
+	defer absorbShutdownErr()
+	b.logf("ShowMessage callback: %v", params.Message)
+	return nil
+}
+
+func (b *buildConfigGopls) ShowMessageRequest(ctxt context.Context, params *protocol.ShowMessageRequestParams) (*protocol.MessageActionItem, error) {
+	defer absorbShutdownErr()
+	b.logf("ShowMessageRequest callback: %v", pretty.Sprint(params))
+	return nil, nil
+}
+
+func (b *buildConfigGopls) ShowDocument(ctxt context.Context, params *protocol.ShowDocumentParams) (*protocol.ShowDocumentResult, error) {
+	defer absorbShutdownErr()
+	b.logf("ShowDocument callback: %v", pretty.Sprint(params))
+	return &protocol.ShowDocumentResult{Success: false}, nil
+}
+
+func (b *buildConfigGopls) ApplyEdit(ctxt context.Context, params *protocol.ApplyWorkspaceEditParams) (*protocol.ApplyWorkspaceEditResult, error) {
+	defer absorbShutdownErr()
+	b.logf("ApplyEdit: %v", pretty.Sprint(params))
+	return &protocol.ApplyWorkspaceEditResult{
+		FailureReason: "edits are only applied for the main build configuration",
+	}, nil
+}
+
+func (b *buildConfigGopls) LogTrace(context.Context, *protocol.LogTraceParams) error {
+	return nil
+}
+
+func (b *buildConfigGopls) Progress(context.Context, *protocol.ProgressParams) error {
+	return nil
+}
+
+func (b *buildConfigGopls) WorkDoneProgressCreate(context.Context, *protocol.WorkDoneProgressCreateParams) error {
+	return nil
+}
+
+func (b *buildConfigGopls) RegisterCapability(context.Context, *protocol.RegistrationParams) error {
+	return nil
+}
+
+func (b *buildConfigGopls) UnregisterCapability(context.Context, *protocol.UnregistrationParams) error {
+	return nil
+}
+
+func (b *buildConfigGopls) Event(context.Context, *interface{}) error {
+	return nil
+}
+
+func (b *buildConfigGopls) CodeLensRefresh(context.Context) error {
+	return nil
+}
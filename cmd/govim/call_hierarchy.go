@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+)
+
+const (
+	callHierarchyIncoming = "incoming"
+	callHierarchyOutgoing = "outgoing"
+
+	callHierarchyBufName = "govim-call-hierarchy"
+)
+
+func (v *vimstate) callHierarchy(flags govim.CommandFlags, args ...string) error {
+	outgoing := false
+	if len(args) == 1 {
+		switch args[0] {
+		case callHierarchyIncoming:
+		case callHierarchyOutgoing:
+			outgoing = true
+		default:
+			return fmt.Errorf("unknown direction %q; expected %q or %q", args[0], callHierarchyIncoming, callHierarchyOutgoing)
+		}
+	}
+	b, pos, err := v.bufCursorPos()
+	if err != nil {
+		return fmt.Errorf("failed to get current position: %v", err)
+	}
+	params := &protocol.CallHierarchyPrepareParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{
+				URI: protocol.DocumentURI(b.URI()),
+			},
+			Position: pos.ToPosition(),
+		},
+	}
+	items, err := v.server.PrepareCallHierarchy(context.Background(), params)
+	if err != nil {
+		return fmt.Errorf("call to gopls.PrepareCallHierarchy failed: %v", err)
+	}
+	if len(items) == 0 {
+		v.ChannelEx(`echom "No function at cursor position"`)
+		return nil
+	}
+	var roots []*hierarchyNode
+	for _, item := range items {
+		loc := protocol.Location{URI: item.URI, Range: item.SelectionRange}
+		roots = append(roots, v.callHierarchyNode(item, loc, outgoing))
+	}
+	return v.openHierarchy(flags.Mods, callHierarchyBufName, roots)
+}
+
+// callHierarchyNode returns a hierarchy node for item that jumps to loc. The
+// children of the node are the incoming or outgoing calls of item, depending
+// on outgoing.
+func (v *vimstate) callHierarchyNode(item protocol.CallHierarchyItem, loc protocol.Location, outgoing bool) *hierarchyNode {
+	return &hierarchyNode{
+		name: item.Name,
+		loc:  loc,
+		expand: func() ([]*hierarchyNode, error) {
+			if outgoing {
+				return v.outgoingCalls(item)
+			}
+			return v.incomingCalls(item)
+		},
+	}
+}
+
+func (v *vimstate) incomingCalls(item protocol.CallHierarchyItem) ([]*hierarchyNode, error) {
+	params := &protocol.CallHierarchyIncomingCallsParams{
+		Item: item,
+	}
+	calls, err := v.server.IncomingCalls(context.Background(), params)
+	if err != nil {
+		return nil, fmt.Errorf("call to gopls.IncomingCalls failed: %v", err)
+	}
+	var res []*hierarchyNode
+	for _, c := range calls {
+		// Jump to the first call site within the caller
+		loc := protocol.Location{URI: c.From.URI, Range: c.From.SelectionRange}
+		if len(c.FromRanges) > 0 {
+			loc.Range = c.FromRanges[0]
+		}
+		res = append(res, v.callHierarchyNode(c.From, loc, false))
+	}
+	return res, nil
+}
+
+func (v *vimstate) outgoingCalls(item protocol.CallHierarchyItem) ([]*hierarchyNode, error) {
+	params := &protocol.CallHierarchyOutgoingCallsParams{
+		Item: item,
+	}
+	calls, err := v.server.OutgoingCalls(context.Background(), params)
+	if err != nil {
+		return nil, fmt.Errorf("call to gopls.OutgoingCalls failed: %v", err)
+	}
+	var res []*hierarchyNode
+	for _, c := range calls {
+		loc := protocol.Location{URI: c.To.URI, Range: c.To.SelectionRange}
+		res = append(res, v.callHierarchyNode(c.To, loc, true))
+	}
+	return res, nil
+}
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 
 	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
 	"github.com/govim/govim/cmd/govim/internal/types"
 )
@@ -70,3 +71,101 @@ func (v *vimstate) runGoTest(flags govim.CommandFlags, args ...string) error {
 
 	return err
 }
+
+// codeActionKinds are the kinds of code action requested by
+// CommandCodeAction. Each kind also covers the kinds it prefixes, e.g.
+// refactor covers refactor.extract, refactor.inline and refactor.rewrite.
+var codeActionKinds = []protocol.CodeActionKind{
+	protocol.QuickFix,
+	protocol.Refactor,
+	protocol.Source,
+}
+
+func (v *vimstate) codeAction(flags govim.CommandFlags, args ...string) error {
+	b, pos, err := v.bufCursorPos()
+	if err != nil {
+		return fmt.Errorf("failed to determine cursor position: %v", err)
+	}
+	rng := protocol.Range{Start: pos.ToPosition(), End: pos.ToPosition()}
+	if *flags.Range != 0 {
+		start, end, err := v.rangeFromFlags(b, flags)
+		if err != nil {
+			return err
+		}
+		rng = protocol.Range{Start: start.ToPosition(), End: end.ToPosition()}
+	}
+
+	var coveredDiags []protocol.Diagnostic
+	v.diagnosticsChangedLock.Lock()
+	if diags, ok := v.rawDiagnostics[b.URI()]; ok {
+		for _, d := range diags.Diagnostics {
+			if rng.Start.Line <= d.Range.End.Line && rng.End.Line >= d.Range.Start.Line {
+				coveredDiags = append(coveredDiags, d)
+			}
+		}
+	}
+	v.diagnosticsChangedLock.Unlock()
+
+	codeActions, err := v.server.CodeAction(context.Background(), &protocol.CodeActionParams{
+		TextDocument: b.ToTextDocumentIdentifier(),
+		Range:        rng,
+		Context: protocol.CodeActionContext{
+			Diagnostics: coveredDiags,
+			Only:        codeActionKinds,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("codeAction failed: %v", err)
+	}
+
+	var fixes []suggestedFix
+	var items []string
+	for i := range codeActions {
+		ca := codeActions[i]
+		if ca.Disabled != nil {
+			continue
+		}
+		fix := suggestedFix{msg: ca.Title, command: ca.Command, edit: ca.Edit}
+		if ca.Command == nil && len(ca.Edit.DocumentChanges) == 0 {
+			// The edit is computed lazily, which requires the server to be
+			// able to resolve it; there is nothing to apply otherwise.
+			if ca.Data == nil || !v.codeActionResolveSupported() {
+				continue
+			}
+			fix.resolve = &ca
+		}
+		fixes = append(fixes, fix)
+		items = append(items, fmt.Sprintf("%s (%s)", ca.Title, ca.Kind))
+	}
+	if len(fixes) == 0 {
+		v.ChannelEx(`echom "No code actions available"`)
+		return nil
+	}
+
+	// Any existing popup of suggested fixes is replaced
+	for popupID := range v.suggestedFixesPopups {
+		v.ChannelCall("popup_close", popupID)
+		delete(v.suggestedFixesPopups, popupID)
+	}
+	opts := make(map[string]interface{})
+	opts["line"] = "cursor+1"
+	opts["col"] = "cursor"
+	opts["pos"] = "topleft"
+	opts["title"] = "Code actions"
+	opts["callback"] = "g:GOVIM" + config.FunctionPopupSelection
+	popupID := v.ParseInt(v.ChannelCall("popup_menu", items, opts))
+	v.suggestedFixesPopups[popupID] = fixes
+	return nil
+}
+
+// codeActionResolveSupported reports whether gopls advertises support for
+// codeAction/resolve, i.e. whether code actions without an edit or command
+// can be resolved to one.
+func (v *vimstate) codeActionResolveSupported() bool {
+	opts, ok := v.goplsCapabilities.CodeActionProvider.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	resolve, _ := opts["resolveProvider"].(bool)
+	return resolve
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 
 	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
 	"github.com/govim/govim/cmd/govim/internal/types"
 )
@@ -31,6 +33,7 @@ func (v *vimstate) complete(args ...json.RawMessage) (interface{}, error) {
 		if err != nil {
 			return nil, fmt.Errorf("called to gopls.Completion failed: %v", err)
 		}
+		sortCompletionItems(res.Items)
 
 		// Slightly bizarre (and I'm not entirely sure how it would/should work)
 		// but each returned completion item can specify its own completion start
@@ -55,22 +58,118 @@ func (v *vimstate) complete(args ...json.RawMessage) (interface{}, error) {
 			}
 		}
 		v.lastCompleteResults = res
+		v.completionDocs = nil
 		return start, nil
 	} else {
-		var matches []govim.CompleteItem
-		for _, i := range v.lastCompleteResults.Items {
-			matches = append(matches, govim.CompleteItem{
-				Abbr:     i.Label,
-				Menu:     i.Detail,
-				Word:     i.TextEdit.NewText,
-				Info:     i.Documentation,
-				Dup:      1,
-				UserData: "govim",
-			})
+		return v.completeItems(v.lastCompleteResults.Items), nil
+	}
+}
+
+// completionKindNames are the names of the kinds of completion items shown in
+// the kind column of the completion menu, as used by CompletionKindIcons.
+var completionKindNames = map[protocol.CompletionItemKind]string{
+	protocol.FunctionCompletion:      "function",
+	protocol.MethodCompletion:        "method",
+	protocol.VariableCompletion:      "variable",
+	protocol.FieldCompletion:         "field",
+	protocol.ClassCompletion:         "type",
+	protocol.InterfaceCompletion:     "type",
+	protocol.StructCompletion:        "type",
+	protocol.TypeParameterCompletion: "type",
+	protocol.ConstantCompletion:      "constant",
+	protocol.ModuleCompletion:        "package",
+}
+
+// defaultCompletionKindIcons are the abbreviations shown in the kind column
+// of the completion menu unless overridden by CompletionKindIcons.
+var defaultCompletionKindIcons = map[string]string{
+	"function": "f",
+	"method":   "m",
+	"variable": "v",
+	"field":    "v",
+	"type":     "t",
+	"constant": "c",
+	"package":  "p",
+}
+
+// completionKind returns the text of the kind column of the completion menu
+// for items of kind k.
+func (v *vimstate) completionKind(k protocol.CompletionItemKind) string {
+	name, ok := completionKindNames[k]
+	if !ok {
+		return ""
+	}
+	if icons := v.config.CompletionKindIcons; icons != nil {
+		if icon, ok := (*icons)[name]; ok {
+			return icon
+		}
+	}
+	return defaultCompletionKindIcons[name]
+}
+
+// completionDeprecated returns whether item is deprecated.
+func completionDeprecated(item protocol.CompletionItem) bool {
+	if item.Deprecated {
+		return true
+	}
+	for _, t := range item.Tags {
+		if t == protocol.ComplDeprecated {
+			return true
+		}
+	}
+	return false
+}
+
+// sortCompletionItems sorts items by their sort text, which gopls uses to
+// rank items. As per the LSP spec, the label of an item without sort text is
+// used instead.
+func sortCompletionItems(items []protocol.CompletionItem) {
+	sortText := func(i protocol.CompletionItem) string {
+		if i.SortText != "" {
+			return i.SortText
 		}
+		return i.Label
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return sortText(items[i]) < sortText(items[j])
+	})
+}
 
-		return matches, nil
+// completeItems converts gopls completion items to Vim completion items. The
+// documentation of items is left to completeChanged if
+// CompletionLazyDocumentation is enabled. Deprecated items are struck through
+// via their abbr_hlgroup where Vim supports it, and otherwise in their info
+// popup by completeChanged.
+func (v *vimstate) completeItems(items []protocol.CompletionItem) []govim.CompleteItem {
+	lazyDocs := v.lazyCompletionDocs()
+	var matches []govim.CompleteItem
+	for _, i := range items {
+		word := i.TextEdit.NewText
+		if i.InsertTextFormat == protocol.SnippetTextFormat {
+			word = snippetWord(word)
+		}
+		info := i.Documentation
+		if lazyDocs {
+			// Vim only creates the info popup for items with info, which
+			// is hidden until completeChanged fills it
+			info = " "
+		}
+		var abbrHlgroup string
+		if completionDeprecated(i) && !v.strikeDeprecatedCompletionInfo() {
+			abbrHlgroup = string(config.HighlightCompletionDeprecated)
+		}
+		matches = append(matches, govim.CompleteItem{
+			Abbr:        i.Label,
+			Menu:        i.Detail,
+			Word:        word,
+			Info:        info,
+			Kind:        v.completionKind(i.Kind),
+			Dup:         1,
+			UserData:    "govim",
+			AbbrHlgroup: abbrHlgroup,
+		})
 	}
+	return matches
 }
 
 func (v *vimstate) completeDone(args ...json.RawMessage) error {
@@ -87,18 +186,38 @@ func (v *vimstate) completeDone(args ...json.RawMessage) error {
 	if chosen.UserData != "govim" {
 		return nil
 	}
-	var match *protocol.CompletionItem
-	for _, c := range v.lastCompleteResults.Items {
-		if c.Label == chosen.Abbr && c.Detail == chosen.Menu {
-			match = &c
-			break
-		}
-	}
+	match := v.completionItemFor(chosen)
 	if match == nil {
 		return fmt.Errorf("failed to find match for completed item %#v", chosen)
 	}
-	if len(match.AdditionalTextEdits) == 0 {
+	isSnippet := match.InsertTextFormat == protocol.SnippetTextFormat
+	if isSnippet {
+		if err := v.expandSnippet(b, chosen.Word, match.TextEdit.NewText); err != nil {
+			return err
+		}
+	}
+	if len(match.AdditionalTextEdits) > 0 {
+		if err := v.applyProtocolTextEdits(b, match.AdditionalTextEdits); err != nil {
+			return err
+		}
+	}
+	if isSnippet {
+		v.jumpToFirstPlaceholder()
+	}
+	v.asyncCompletionDone(b)
+	return nil
+}
+
+// completionItemFor returns the item of the last completion results from
+// which chosen was derived, or nil if there is no such item.
+func (v *vimstate) completionItemFor(chosen govim.CompleteItem) *protocol.CompletionItem {
+	if v.lastCompleteResults == nil {
 		return nil
 	}
-	return v.applyProtocolTextEdits(b, match.AdditionalTextEdits)
+	for i, c := range v.lastCompleteResults.Items {
+		if c.Label == chosen.Abbr && c.Detail == chosen.Menu {
+			return &v.lastCompleteResults.Items[i]
+		}
+	}
+	return nil
 }
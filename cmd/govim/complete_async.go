@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/fuzzy"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+// asyncCompletion is the state of completion as you type, see
+// CompletionAsync.
+type asyncCompletion struct {
+	// cancel cancels the Completion request in flight, if any
+	cancel context.CancelFunc
+
+	// doneTick is the changedtick of the buffer once an item was last
+	// completed, such that the change made by completing does not trigger
+	// completion again
+	doneTick int
+
+	// bufnr, line and col locate the start of the items of the last response
+	bufnr int
+	line  int
+	col   int
+
+	// prefix is the text between col and the cursor when the items of the
+	// last response were requested
+	prefix string
+
+	// items are the items of the last response, which are re-filtered as long
+	// as the text typed extends prefix
+	items []protocol.CompletionItem
+
+	// autocmds indicates whether the autocommands in asyncCompleteGroup are
+	// defined
+	autocmds bool
+}
+
+// asyncCompleteGroup is the augroup of the autocommands for completion as you
+// type, which each cost a round trip to govim for every key typed in insert
+// mode. They are therefore only defined whilst CompletionAsync is enabled.
+const asyncCompleteGroup = "govimCompleteAsync"
+
+func (v *vimstate) asyncCompletionEnabled() bool {
+	return v.config.CompletionAsync != nil && *v.config.CompletionAsync
+}
+
+// updateAsyncCompleteAutoCommands defines the autocommands in
+// asyncCompleteGroup if CompletionAsync is enabled, and removes them
+// otherwise.
+func (v *vimstate) updateAsyncCompleteAutoCommands() {
+	enabled := v.asyncCompletionEnabled()
+	if enabled == v.asyncCompletion.autocmds {
+		return
+	}
+	v.asyncCompletion.autocmds = enabled
+	if !enabled {
+		v.ChannelExf("autocmd! %v", asyncCompleteGroup)
+		v.cancelAsyncCompletion()
+		v.asyncCompletion.items = nil
+		return
+	}
+	v.ChannelExf("augroup %v | augroup END", asyncCompleteGroup)
+	v.DefineAutoCommand(asyncCompleteGroup, govim.Events{govim.EventInsertCharPre}, govim.Patterns{"*.go"}, false, v.asyncCompleteCharPre)
+	v.DefineAutoCommand(asyncCompleteGroup, govim.Events{govim.EventTextChangedI, govim.EventTextChangedP}, govim.Patterns{"*.go"}, false, v.asyncCompleteTextChanged, exprAsyncCompleteInfo)
+}
+
+// asyncCompleteCharPre cancels the Completion request in flight, if any,
+// because its response would be stale once the character typed is inserted.
+func (v *vimstate) asyncCompleteCharPre(args ...json.RawMessage) error {
+	if !v.asyncCompletionEnabled() {
+		return nil
+	}
+	v.cancelAsyncCompletion()
+	return nil
+}
+
+func (v *vimstate) cancelAsyncCompletion() {
+	if c := v.asyncCompletion.cancel; c != nil {
+		c()
+		v.asyncCompletion.cancel = nil
+	}
+}
+
+// asyncCompleteTextChanged shows completion candidates for the identifier or
+// selector being typed, either by re-filtering the items of the last response
+// or by requesting new items from gopls.
+func (v *vimstate) asyncCompleteTextChanged(args ...json.RawMessage) error {
+	if !v.asyncCompletionEnabled() {
+		return nil
+	}
+	var info struct {
+		BufNr       int
+		Line        int
+		Col         int
+		Text        string
+		ChangedTick int
+		Selected    int
+	}
+	v.Parse(args[0], &info)
+	a := &v.asyncCompletion
+	if info.Selected != -1 || info.ChangedTick == a.doneTick {
+		// The change is the result of selecting or completing an item
+		return nil
+	}
+	b, ok := v.buffers[info.BufNr]
+	if !ok || info.Col < 1 || info.Col-1 > len(info.Text) {
+		return nil
+	}
+	before := info.Text[:info.Col-1]
+	if !strings.HasSuffix(before, ".") && identSuffix(before) == "" {
+		// Neither an identifier nor a selector is being typed
+		v.cancelAsyncCompletion()
+		a.items = nil
+		return nil
+	}
+	if a.items != nil && a.bufnr == info.BufNr && a.line == info.Line && a.col <= info.Col {
+		typed := before[a.col-1:]
+		if strings.HasPrefix(typed, a.prefix) && identSuffix(typed[len(a.prefix):]) == typed[len(a.prefix):] {
+			v.cancelAsyncCompletion()
+			v.showAsyncCompletion(typed)
+			return nil
+		}
+	}
+	return v.requestAsyncCompletion(b, info.Line, info.Col)
+}
+
+// requestAsyncCompletion requests completion items for the position line,
+// col in b, cancelling any request in flight. The items are shown once they
+// arrive, unless another request has been made in the meantime.
+func (v *vimstate) requestAsyncCompletion(b *types.Buffer, line, col int) error {
+	pos, err := types.PointFromVim(b, line, col)
+	if err != nil {
+		return fmt.Errorf("failed to get completion position: %v", err)
+	}
+	v.cancelAsyncCompletion()
+	ctx, cancel := context.WithCancel(context.Background())
+	v.asyncCompletion.cancel = cancel
+	params := &protocol.CompletionParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: b.ToTextDocumentIdentifier(),
+			Position:     pos.ToPosition(),
+		},
+	}
+	version := b.Version
+	v.tomb.Go(func() error {
+		res, err := v.goplsServer().Completion(ctx, params)
+		v.govimplugin.Schedule(func(govim.Govim) error {
+			// If the context is cancelled, the text has changed since the
+			// request was made and the response is no longer relevant
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+			cancel()
+			v.asyncCompletion.cancel = nil
+			if err != nil {
+				v.Logf("async completion failed: %v", err)
+				return nil
+			}
+			if b.Version != version || v.ParseString(v.ChannelExpr("mode()")) != "i" {
+				return nil
+			}
+			return v.handleAsyncCompletion(b, line, col, res)
+		})
+		return nil
+	})
+	return nil
+}
+
+func (v *vimstate) handleAsyncCompletion(b *types.Buffer, line, col int, res *protocol.CompletionList) error {
+	a := &v.asyncCompletion
+	a.items = nil
+	v.completionDocs = nil
+	if res == nil || len(res.Items) == 0 {
+		return nil
+	}
+	sortCompletionItems(res.Items)
+	// As for omnifunc, the start of the first item is taken as the start of
+	// all items
+	start, err := types.PointFromPosition(b, res.Items[0].TextEdit.Range.Start)
+	if err != nil {
+		return fmt.Errorf("failed to derive completion start: %v", err)
+	}
+	text, err := b.Line(line)
+	if err != nil {
+		return fmt.Errorf("failed to get line %v: %v", line, err)
+	}
+	if start.Line() != line || start.Col() > col || col-1 > len(text) {
+		return nil
+	}
+	a.bufnr = b.Num
+	a.line = line
+	a.col = start.Col()
+	a.prefix = text[start.Col()-1 : col-1]
+	a.items = res.Items
+	v.showAsyncCompletion(a.prefix)
+	return nil
+}
+
+// showAsyncCompletion shows the items of the last response that match typed,
+// the text typed since their start, via Vim's complete().
+func (v *vimstate) showAsyncCompletion(typed string) {
+	a := &v.asyncCompletion
+	items := filterCompletionItems(a.items, typed)
+	v.lastCompleteResults = &protocol.CompletionList{Items: items}
+	matches := v.completeItems(items)
+	for i := range matches {
+		// The items have already been filtered
+		matches[i].Equal = 1
+	}
+	if matches == nil {
+		matches = []govim.CompleteItem{}
+	}
+	v.ChannelCall("complete", a.col, matches)
+}
+
+// asyncCompletionDone records that an item was completed in b, such that
+// the resulting change does not trigger completion again.
+func (v *vimstate) asyncCompletionDone(b *types.Buffer) {
+	if !v.asyncCompletionEnabled() {
+		return
+	}
+	v.cancelAsyncCompletion()
+	v.asyncCompletion.items = nil
+	v.asyncCompletion.doneTick = v.ParseInt(v.ChannelExprf("getbufvar(%v, 'changedtick')", b.Num))
+}
+
+// filterCompletionItems returns the items that fuzzy match pattern, best
+// matches first. Items that match equally well retain their order.
+func filterCompletionItems(items []protocol.CompletionItem, pattern string) []protocol.CompletionItem {
+	if pattern == "" {
+		return items
+	}
+	type match struct {
+		item  protocol.CompletionItem
+		score float32
+	}
+	m := fuzzy.NewMatcher(pattern)
+	var matches []match
+	for _, i := range items {
+		text := i.FilterText
+		if text == "" {
+			text = i.Label
+		}
+		if s := m.Score(text); s > 0 {
+			matches = append(matches, match{i, s})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	res := make([]protocol.CompletionItem, len(matches))
+	for i, m := range matches {
+		res[i] = m.item
+	}
+	return res
+}
+
+// identSuffix returns the identifier, if any, at the end of s.
+func identSuffix(s string) string {
+	i := len(s)
+	for i > 0 {
+		r, size := utf8.DecodeLastRuneInString(s[:i])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		i -= size
+	}
+	// Identifiers do not start with a digit
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !unicode.IsDigit(r) {
+			break
+		}
+		i += size
+	}
+	return s[i:]
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+func (v *vimstate) lazyCompletionDocs() bool {
+	return v.config.CompletionLazyDocumentation != nil && *v.config.CompletionLazyDocumentation
+}
+
+// completionDocsKey identifies item in the cache of info popup lines.
+func completionDocsKey(item protocol.CompletionItem) string {
+	return item.Label + "\x00" + item.Detail
+}
+
+// completeChangedGroup is the augroup of the CompleteChanged autocommand,
+// which costs a round trip to govim every time the selected completion item
+// changes. It is therefore only defined whilst needsCompleteChanged.
+const completeChangedGroup = "govimCompleteChanged"
+
+// needsCompleteChanged reports whether the CompleteChanged autocommand is
+// needed, to fill the info popup of the completion menu if
+// CompletionLazyDocumentation is enabled or to strike through the info popup
+// of deprecated items.
+func (v *vimstate) needsCompleteChanged() bool {
+	return v.lazyCompletionDocs() || v.strikeDeprecatedCompletionInfo()
+}
+
+// strikeDeprecatedCompletionInfo reports whether deprecated completion items
+// are struck through in the info popup of the completion menu, via a text
+// property, because Vim does not support abbr_hlgroup which would strike them
+// through in the menu itself. Only the first line of the info popup is struck
+// through, i.e. the signature of the item or otherwise the start of its
+// documentation.
+func (v *vimstate) strikeDeprecatedCompletionInfo() bool {
+	return !v.hasAbbrHlgroup
+}
+
+// updateCompleteChangedAutoCommands defines the autocommand in
+// completeChangedGroup if needsCompleteChanged, and removes it otherwise.
+func (v *vimstate) updateCompleteChangedAutoCommands() {
+	enabled := v.needsCompleteChanged()
+	if enabled == v.completeChangedAutocmds {
+		return
+	}
+	v.completeChangedAutocmds = enabled
+	if !enabled {
+		v.ChannelExf("autocmd! %v", completeChangedGroup)
+		v.cancelCompletionDocs()
+		v.completionDocs = nil
+		return
+	}
+	v.ChannelExf("augroup %v | augroup END", completeChangedGroup)
+	v.DefineAutoCommand(completeChangedGroup, govim.Events{govim.EventCompleteChanged}, govim.Patterns{"*.go"}, false, v.completeChanged, "v:event.completed_item")
+}
+
+// completeChanged shows the signature and documentation of the item selected
+// in the completion menu in the info popup of the menu, if
+// CompletionLazyDocumentation is enabled. gopls is then told not to compute
+// the documentation of completion items, so unless cached the documentation
+// of the selected item is fetched via a hover request at the text inserted
+// for it. The info popup of deprecated items is struck through unless Vim
+// supports abbr_hlgroup, see strikeDeprecatedCompletionInfo.
+func (v *vimstate) completeChanged(args ...json.RawMessage) error {
+	v.cancelCompletionDocs()
+	var chosen govim.CompleteItem
+	v.Parse(args[0], &chosen)
+	if chosen.UserData != "govim" {
+		return nil
+	}
+	match := v.completionItemFor(chosen)
+	if match == nil {
+		return nil
+	}
+	item := *match
+	deprecated := completionDeprecated(item) && v.strikeDeprecatedCompletionInfo()
+	if !v.lazyCompletionDocs() {
+		// Vim fills the info popup from the info of the item
+		v.govimplugin.Schedule(func(govim.Govim) error {
+			if id := v.ParseInt(v.ChannelCall("popup_findinfo")); id != 0 {
+				v.strikeCompletionInfo(id, deprecated)
+			}
+			return nil
+		})
+		return nil
+	}
+	key := completionDocsKey(item)
+	if lines, ok := v.completionDocs[key]; ok {
+		v.govimplugin.Schedule(func(govim.Govim) error {
+			v.showCompletionDocs(lines, deprecated)
+			return nil
+		})
+		return nil
+	}
+	params, ok := v.completionHoverParams(chosen.Word)
+	if !ok {
+		// The item has not been inserted, e.g. completeopt includes noinsert,
+		// so there is nothing to hover over: show just its signature, without
+		// caching it such that its documentation is fetched once inserted.
+		lines := completionDocLines(item)
+		v.govimplugin.Schedule(func(govim.Govim) error {
+			v.showCompletionDocs(lines, deprecated)
+			return nil
+		})
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancelCompletionDocsFn = cancel
+	v.tomb.Go(func() error {
+		res, err := v.goplsServer().Hover(ctx, params)
+		v.govimplugin.Schedule(func(govim.Govim) error {
+			// If the context is cancelled, another item has been selected
+			// since the request was made
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+			cancel()
+			v.cancelCompletionDocsFn = nil
+			if err != nil {
+				v.Logf("failed to get documentation of completion item %q: %v", item.Label, err)
+			} else if res != nil {
+				item.Documentation = hoverDocumentation(res.Contents.Value)
+			}
+			lines := completionDocLines(item)
+			v.cacheCompletionDocs(key, lines)
+			v.showCompletionDocs(lines, deprecated)
+			return nil
+		})
+		return nil
+	})
+	return nil
+}
+
+// cancelCompletionDocs cancels the request for the documentation of the
+// selected completion item in flight, if any.
+func (v *vimstate) cancelCompletionDocs() {
+	if v.cancelCompletionDocsFn != nil {
+		v.cancelCompletionDocsFn()
+		v.cancelCompletionDocsFn = nil
+	}
+}
+
+// completionHoverParams returns the parameters of a hover request for the
+// completion item whose text word has just been inserted before the cursor.
+// ok is false if word is not before the cursor.
+func (v *vimstate) completionHoverParams(word string) (params *protocol.HoverParams, ok bool) {
+	b, cp, err := v.bufCursorPos()
+	if err != nil || word == "" {
+		return nil, false
+	}
+	if !bytes.HasSuffix(b.Contents()[:cp.Offset()], []byte(word)) {
+		return nil, false
+	}
+	// word starts with the identifier of the item, whatever follows it
+	pos, err := types.PointFromOffset(b, cp.Offset()-len(word))
+	if err != nil {
+		return nil, false
+	}
+	return &protocol.HoverParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: b.ToTextDocumentIdentifier(),
+			Position:     pos.ToPosition(),
+		},
+	}, true
+}
+
+// hoverDocumentation returns the documentation in the plain text contents of
+// a hover, which gopls formats as the declaration of the identifier,
+// possibly spanning several lines, followed by its documentation.
+func hoverDocumentation(contents string) string {
+	lines := strings.Split(contents, "\n")
+	depth := 0
+	for i, l := range lines {
+		depth += strings.Count(l, "(") + strings.Count(l, "{") - strings.Count(l, ")") - strings.Count(l, "}")
+		if depth <= 0 {
+			return strings.TrimSpace(strings.Join(lines[i+1:], "\n"))
+		}
+	}
+	return ""
+}
+
+func (v *vimstate) cacheCompletionDocs(key string, lines []string) {
+	if v.completionDocs == nil {
+		v.completionDocs = make(map[string][]string)
+	}
+	v.completionDocs[key] = lines
+}
+
+// showCompletionDocs fills the info popup of the completion menu, if any,
+// with lines, highlighted as Go.
+func (v *vimstate) showCompletionDocs(lines []string, deprecated bool) {
+	id := v.ParseInt(v.ChannelCall("popup_findinfo"))
+	if id == 0 {
+		// The completion menu has been closed
+		return
+	}
+	if len(lines) == 0 {
+		v.ChannelCall("popup_hide", id)
+		return
+	}
+	v.ChannelCall("popup_settext", id, lines)
+	v.ChannelExf("call setbufvar(winbufnr(%v), '&syntax', 'go')", id)
+	v.strikeCompletionInfo(id, deprecated)
+	v.ChannelCall("popup_show", id)
+}
+
+// strikeCompletionInfo strikes through the first line of the info popup id if
+// deprecated, i.e. the signature of a deprecated item or the start of its
+// documentation. Otherwise any strike through is removed, because Vim reuses
+// the info popup as the selected item changes.
+func (v *vimstate) strikeCompletionInfo(id int, deprecated bool) {
+	bufnr := v.ParseInt(v.ChannelCall("winbufnr", id))
+	v.ChannelCall("prop_remove", struct {
+		Type  string `json:"type"`
+		BufNr int    `json:"bufnr"`
+		All   int    `json:"all"`
+	}{string(config.HighlightCompletionDeprecated), bufnr, 1})
+	if !deprecated {
+		return
+	}
+	var lines []string
+	v.Parse(v.ChannelCall("getbufline", bufnr, 1), &lines)
+	if len(lines) == 0 || lines[0] == "" {
+		return
+	}
+	v.ChannelCall("prop_add", 1, 1, propAddDict{
+		Type:    string(config.HighlightCompletionDeprecated),
+		EndLine: 1,
+		EndCol:  len(lines[0]) + 1,
+		BufNr:   bufnr,
+	})
+}
+
+// completionDocLines returns the lines of the info popup for item: its
+// signature, followed by its documentation as Go comments such that both
+// are highlighted correctly as Go.
+func completionDocLines(item protocol.CompletionItem) []string {
+	var lines []string
+	if sig := completionSignature(item); sig != "" {
+		lines = append(lines, sig)
+	}
+	if doc := strings.TrimSpace(item.Documentation); doc != "" {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		for _, l := range strings.Split(doc, "\n") {
+			lines = append(lines, strings.TrimRight("// "+l, " "))
+		}
+	}
+	return lines
+}
+
+// completionSignature returns the Go declaration of item, as far as it can be
+// derived from its label and detail.
+func completionSignature(item protocol.CompletionItem) string {
+	switch item.Kind {
+	case protocol.FunctionCompletion, protocol.MethodCompletion:
+		if strings.HasPrefix(item.Detail, "func") {
+			return "func " + item.Label + strings.TrimPrefix(item.Detail, "func")
+		}
+	case protocol.ClassCompletion, protocol.InterfaceCompletion, protocol.StructCompletion, protocol.TypeParameterCompletion:
+		return "type " + item.Label + " " + item.Detail
+	case protocol.ConstantCompletion:
+		return "const " + item.Label + " " + item.Detail
+	case protocol.VariableCompletion:
+		return "var " + item.Label + " " + item.Detail
+	case protocol.ModuleCompletion:
+		return "package " + item.Label
+	}
+	if item.Detail == "" {
+		return item.Label
+	}
+	return item.Label + " " + item.Detail
+}
@@ -2,6 +2,8 @@
 // used by govim
 package config
 
+import "strings"
+
 const (
 	InternalFunctionPrefix = "_internal_"
 )
@@ -95,6 +97,22 @@ type Config struct {
 	// Default: true
 	HighlightReferences *bool `json:",omitempty"`
 
+	// HighlightSemanticTokens is a boolean (0 or 1 in VimScript) that controls
+	// whether identifiers are highlighted according to the semantic tokens
+	// reported by gopls, something that allows package names, type parameters,
+	// and mutable versus readonly variables (constants) to be told apart.
+	// Highlights are added as text properties to the visible lines of Go
+	// buffers, and are updated as buffers change or windows scroll.
+	//
+	// Override the vim highlight groups GOVIMSemanticNamespace,
+	// GOVIMSemanticType, GOVIMSemanticInterface, GOVIMSemanticTypeParameter,
+	// GOVIMSemanticParameter, GOVIMSemanticVariable,
+	// GOVIMSemanticReadonlyVariable, GOVIMSemanticFunction and
+	// GOVIMSemanticMethod to alter the text property styles.
+	//
+	// Default: false
+	HighlightSemanticTokens *bool `json:",omitempty"`
+
 	// HoverDiagnostics is a boolean (0 or 1 in VimScript) that controls
 	// whether diagnostics should be shown in the hover popup. When enabled
 	// each diagnostic that covers the cursor/mouse position will be added
@@ -120,15 +138,86 @@ type Config struct {
 	// Default: CompletionMatcherFuzzy
 	CompletionMatcher *CompletionMatcher `json:",omitempty"`
 
+	// CompletionSnippets enables snippets in completion candidates, e.g. the
+	// parameters of a function call being inserted as placeholders. The
+	// placeholders can be jumped between via FunctionSnippetNext and
+	// FunctionSnippetPrev, mapped to <C-j> and <C-k> in insert and select
+	// mode while a snippet is active. A tabstop that appears more than once
+	// in a snippet is jumped to at each appearance in turn, and typing at one
+	// does not change the others.
+	//
+	// Changes take effect when gopls is next started, see
+	// CommandRestartGopls.
+	//
+	// Default: false
+	CompletionSnippets *bool `json:",omitempty"`
+
+	// CompletionAsync enables completion as you type. Typing an identifier or
+	// a selector requests completion candidates from gopls in the background,
+	// cancelling any request in flight, and the candidates are shown via
+	// Vim's complete() once they arrive. Candidates are then re-filtered
+	// locally as more of the identifier is typed. Use with completeopt
+	// including noinsert and noselect, e.g.:
+	//
+	//   set completeopt=menuone,noinsert,noselect
+	//
+	// Default: false
+	CompletionAsync *bool `json:",omitempty"`
+
+	// CompletionLazyDocumentation defers fetching the documentation of
+	// completion candidates until a candidate is selected in the completion
+	// menu: gopls does not compute documentation for completion candidates,
+	// which makes completion faster, and instead the documentation of the
+	// selected candidate is fetched via a hover request once it has been
+	// inserted. The signature and documentation of the selected candidate are
+	// then shown, with Go syntax highlighting, in the info popup of the menu.
+	// Documentation is cached per candidate until the next completion. If
+	// completeopt includes noinsert, only the signature of the selected
+	// candidate is shown. Use with completeopt including popuphidden, e.g.:
+	//
+	//   set completeopt=menuone,popuphidden
+	//
+	// Default: false
+	CompletionLazyDocumentation *bool `json:",omitempty"`
+
+	// CompletionKindIcons is a map of strings used to override the text in the
+	// kind column of the completion menu for specific kinds of candidates. Kinds
+	// are "function", "method", "variable", "field", "type", "constant" and
+	// "package", shown as "f", "m", "v", "v", "t", "c" and "p" respectively by
+	// default.
+	//
+	// Example: govim#config#Set("CompletionKindIcons", {"function": "F", "type": "T"})
+	//
+	// Default: nil
+	CompletionKindIcons *map[string]string `json:",omitempty"`
+
+	// SignatureHelpAuto enables signature help while typing the arguments of
+	// a call. Typing ( or , in insert mode shows the signature of the
+	// enclosing call in a popup, which is kept open and updated as the cursor
+	// moves between arguments until it leaves the call or insert mode ends.
+	// The active parameter is highlighted via the GOVIMSignatureParam
+	// highlight group. Where there are multiple signatures,
+	// FunctionSignatureHelpCycle, mapped to <C-l> in insert mode while the
+	// popup is shown (in Vim v8.2.1978 or later), cycles through them.
+	//
+	// Default: false
+	SignatureHelpAuto *bool `json:",omitempty"`
+
 	// SymbolMatcher is a string value that tells gopls which matcher
 	// to use when computing workspace symbol candidates.
 	//
+	// Changes take effect when gopls is next started, see
+	// CommandRestartGopls.
+	//
 	// Default: SymbolMatcherFuzzy
 	SymbolMatcher *SymbolMatcher `json:",omitempty"`
 
 	// SymbolStyle is a string value that tells gopls which qualification style
 	// to use when computing workspace symbol candidates.
 	//
+	// Changes take effect when gopls is next started, see
+	// CommandRestartGopls.
+	//
 	// Default: SymbolStyleFull
 	SymbolStyle *SymbolStyle `json:",omitempty"`
 
@@ -187,6 +276,22 @@ type Config struct {
 	// https://github.com/golang/tools/blob/master/gopls/doc/settings.md#directoryfilters-string
 	GoplsDirectoryFilters *[]string `json:",omitempty"`
 
+	// ExtraBuildConfigs lists build configurations, in addition to the one
+	// defined by GoplsEnv, under which code is diagnosed. govim runs an
+	// additional gopls instance for each, and the diagnostics it reports are
+	// merged with those of the main instance, prefixed with the name of the
+	// configuration (e.g. "windows/amd64: undefined: foo"). Diagnostics
+	// reported identically under the main configuration are not repeated.
+	// All other functionality (completion, hover etc) uses the main instance.
+	// For example:
+	//
+	//    [{"GOOS": "windows", "GOARCH": "amd64"}, {"Tags": ["integration"]}]
+	//
+	// Changes take effect when gopls is next started, see CommandRestartGopls.
+	//
+	// Default: []
+	ExtraBuildConfigs *[]BuildConfig `json:",omitempty"`
+
 	// Analyses is a map of booleans (0 or 1 in VimScript) used to enable/disable
 	// specific analyses in gopls. Entries in the map are used to override
 	// defaults specified by gopls. A list of analyzers with their default value
@@ -199,6 +304,36 @@ type Config struct {
 	// Default: nil
 	Analyses *map[string]bool `json:",omitempty"`
 
+	// InlayHints is a map of booleans (0 or 1 in VimScript) used to enable/disable
+	// specific kinds of inlay hints. Inlay hints are shown as virtual text in the
+	// visible lines of Go buffers, and are refreshed as buffers change or
+	// windows scroll. Kinds include "parameterNames", "assignVariableTypes",
+	// "compositeLiteralFields", "constantValues" and "rangeVariableTypes"; a
+	// full list can be found in the gopls documentation (e.g.
+	// https://cs.opensource.google/go/x/tools/+/master:gopls/doc/inlayHints.md
+	// for master). Inlay hints require Vim 9.0.0067 or later.
+	//
+	// Override the vim highlight group GOVIMInlayHint to alter the style of
+	// the hints.
+	//
+	// Example: govim#config#Set("InlayHints", {"parameterNames": 1, "rangeVariableTypes": 1})
+	//
+	// Default: nil
+	InlayHints *map[string]bool `json:",omitempty"`
+
+	// Folding is a boolean (0 or 1 in VimScript) that controls whether govim
+	// computes folds for Go buffers using the folding ranges reported by
+	// gopls. Imports, comments, function bodies, composite literals and other
+	// blocks are foldable. Folds are recomputed asynchronously each time a
+	// buffer changes and are exposed via the GOVIMFoldExpr() function, which
+	// never blocks. When enabled, govim sets foldmethod=expr and foldexpr to
+	// GOVIMFoldExpr(v:lnum) in windows showing Go buffers, including windows
+	// that later open a Go buffer. When disabled, govim restores the global
+	// value of foldexpr and the previous foldmethod of those windows.
+	//
+	// Default: false
+	Folding *bool `json:",omitempty"`
+
 	// OpenLastProgressWith configures how vim should open the buffer created
 	// when calling :GOVIMLastProgress.
 	// Valid values are any vim command that takes a buffer number as argument.
@@ -213,6 +348,46 @@ type Config struct {
 	// Default: "below 10split"
 	OpenLastProgressWith *string `json:",omitempty"`
 
+	// OpenExternalWith is the command used to open a URI that gopls asks to
+	// be shown in an external program, for example a link to documentation.
+	// The URI is passed as the final argument to the command. Arguments are
+	// split as per go generate, i.e. with support for quoted strings and
+	// environment variables.
+	//
+	// Default: "open" on macOS, "xdg-open" otherwise
+	OpenExternalWith *string `json:",omitempty"`
+
+	// GoplsRemote configures govim to connect to a shared gopls daemon,
+	// rather than running a gopls instance of its own. This allows a number
+	// of Vim instances to share the memory and caches of one gopls. Values
+	// are as for the -remote flag of gopls:
+	//
+	//    "auto"                 - connect to the daemon for the gopls binary
+	//                             and current user, starting one if required
+	//    "auto;id"              - as "auto", but for the daemon named id
+	//    "unix;/path/to/socket" - connect to a daemon started with
+	//                             gopls -listen="unix;/path/to/socket"
+	//
+	// A bare absolute path is treated as the path of a Unix socket. govim
+	// still runs a gopls forwarder of its own, which writes the gopls log file
+	// for this Vim instance. The daemon is shared: when Vim exits, only the
+	// connection to the daemon is closed. A daemon started by "auto" exits a
+	// minute after its last client disconnects.
+	//
+	// Changes take effect when gopls is next started, see CommandRestartGopls.
+	//
+	// Default: "" (govim runs its own gopls)
+	GoplsRemote *string `json:",omitempty"`
+
+	// VulncheckDB is the vulnerability database used by CommandVulncheck, as
+	// per the GOVULNDB environment variable of govulncheck. A file:// URL
+	// (or a bare absolute path) refers to a local copy of the database, e.g. a
+	// mirror for use offline. Multiple databases are separated by commas.
+	//
+	// Default: "" (GOVULNDB from the environment, otherwise
+	// https://vuln.go.dev)
+	VulncheckDB *string `json:",omitempty"`
+
 	// Gofumpt configures gopls to use gofumpt as formatter.
 	// It is a stricter formatter than gofmt, while being backwards compatible.
 	// Read more at: https://github.com/mvdan/gofumpt
@@ -385,6 +560,25 @@ const (
 	// prompted for the new identifier name.
 	CommandRename Command = "Rename"
 
+	// CommandMoveFile moves a file or directory, updating imports across the
+	// workspace as required. With a single argument the file of the current
+	// buffer is moved to that path; with two arguments the first (file or
+	// directory) is moved to the second. Buffers for moved files are renamed
+	// accordingly.
+	//
+	// Where gopls does not compute the changes for a move, govim updates the
+	// import paths of packages that move: those within a moved directory, or
+	// the package of a moved file that was its last (non-test) Go file. Other
+	// references need no changes, because package names are unchanged. A
+	// warning is shown if the changes cannot be computed.
+	//
+	// Buffers with unsaved changes are not moved; write them first.
+	//
+	// Imports are similarly updated when a Go buffer is renamed via :file.
+	// Renaming it via :saveas instead copies the file, leaving the original
+	// and any imports of it as they are.
+	CommandMoveFile Command = "MoveFile"
+
 	// CommandStringFn applies a transformation function to text. Without a
 	// range the current line is used as input. Visual ranges can also be used,
 	// with the exception of visual blocks. The command takes one or more
@@ -401,6 +595,13 @@ const (
 	// CommandSuggestedFixes
 	CommandSuggestedFixes Command = "SuggestedFixes"
 
+	// CommandCodeAction shows a popup menu of the code actions available at
+	// the cursor position, or for the selected range when called with a range,
+	// e.g. ":'<,'>GOVIMCodeAction". This includes quick fixes, refactorings
+	// such as extracting a function or variable, and source actions such as
+	// organizing imports. Selecting an entry applies the action.
+	CommandCodeAction Command = "CodeAction"
+
 	// CommandHighlightReferences highlights references to the identifier under
 	// the cursor. The highlights are removed by a change to any file or a call
 	// to CommandClearReferencesHighlights.
@@ -438,9 +639,83 @@ const (
 	// Enable progress popups to see the test progress.
 	CommandGoTest Command = "GoTest"
 
+	// CommandRunTests runs tests with "go test -json" in the background. The
+	// tests run are the test functions (tests, fuzz tests and examples)
+	// declared within the range of the command in the current buffer, by
+	// default the cursor line, e.g. calling "%GOVIMRunTests" in a _test.go
+	// file runs all tests in that file. If there are no such functions, all
+	// tests of the package of the current buffer are run.
+	//
+	// The results are shown as a tree of packages, tests and subtests with
+	// their outcome and duration in a buffer. Within the buffer, <Tab>
+	// expands or collapses the node under the cursor and <Enter> jumps to its
+	// location. Failing tests are expanded to show their output. The
+	// file:line positions of their output, e.g. from t.Errorf, and any build
+	// errors are added to the quickfix list, and the signs
+	// GOVIMSignTestPass, GOVIMSignTestFail and GOVIMSignTestSkip mark the
+	// outcome of each test function.
+	CommandRunTests Command = "RunTests"
+
+	// CommandRerunTests repeats the last run of CommandRunTests. Calling
+	// ":GOVIMRerunTests failed" only reruns the tests that failed.
+	CommandRerunTests Command = "RerunTests"
+
+	// CommandCoverage toggles the test coverage of the package of the current
+	// buffer. When enabled, "go test -coverprofile" is run in the background
+	// and the covered and uncovered blocks of all open buffers of the package
+	// are highlighted using GOVIMCovered and GOVIMUncovered respectively. The
+	// percentage of statements covered is echoed for each file, and shown as
+	// virtual text where supported. Changing a block invalidates the coverage
+	// of its file, which is then no longer shown.
+	CommandCoverage Command = "Coverage"
+
 	// Open a new buffer that contain the current output from the most recently
 	// created progress popup. Useful for looking at a failed test for example.
 	CommandLastProgress Command = "LastProgress"
+
+	// CommandCancelProgress cancels the ongoing work, e.g. a test run via
+	// CommandGoTest, with the progress token given as argument. Without an
+	// argument, the tokens and titles of all cancellable work in progress are
+	// listed. Tokens are completed on the command line. Closing the progress
+	// popup of cancellable work, e.g. by clicking on it, also cancels the
+	// work. Requires ExperimentalProgressPopups.
+	CommandCancelProgress Command = "CancelProgress"
+
+	// CommandCallHierarchy opens a buffer showing the call hierarchy of the
+	// function at the cursor position. By default the callers of the function
+	// (incoming calls) are shown; ":GOVIMCallHierarchy outgoing" shows the
+	// functions it calls instead. Within the buffer, <Tab> expands or collapses
+	// the node under the cursor and <Enter> jumps to its location: the call
+	// site for incoming calls, the declaration of the callee for outgoing
+	// calls. Jumps can be undone via CommandGoToPrevDef. Command modifiers like
+	// :vertical control how the buffer is split.
+	CommandCallHierarchy Command = "CallHierarchy"
+
+	// CommandTypeHierarchy opens a buffer showing the type hierarchy of the
+	// type at the cursor position. The type can be expanded to show its
+	// supertypes (the interfaces it implements) and its subtypes (the types
+	// that implement it), each of which can in turn be expanded further in the
+	// same direction. The buffer is navigated in the same way as that of
+	// CommandCallHierarchy. Versions of gopls that do not support type
+	// hierarchies only relate interfaces to the concrete types that implement
+	// them, i.e. an interface is not shown as the supertype of another
+	// interface.
+	CommandTypeHierarchy Command = "TypeHierarchy"
+
+	// CommandRestartGopls restarts gopls, telling the new instance about all
+	// loaded buffers. This is required for changes to session-level config,
+	// for example SymbolMatcher and SymbolStyle, to take effect. govim
+	// restarts gopls automatically should it exit unexpectedly.
+	CommandRestartGopls Command = "RestartGopls"
+
+	// CommandVulncheck runs govulncheck, via gopls, on the packages of the
+	// module of the current buffer, using the database configured by
+	// VulncheckDB. An optional package pattern can be supplied; the default is
+	// "./...". Vulnerable code that is called is listed in the quickfix
+	// window, each entry followed by the call stack that reaches it. The
+	// require lines of vulnerable modules in go.mod files are annotated with
+	// the IDs of the vulnerabilities and the versions that fix them.
+	CommandVulncheck Command = "Vulncheck"
 )
 
 type Function string
@@ -479,10 +754,50 @@ const (
 
 	FunctionProgressClosed Function = InternalFunctionPrefix + "ProgressClosed"
 
+	// FunctionProgressTokenComplete is an internal function used by govim to
+	// provide completion of arguments to CommandCancelProgress
+	FunctionProgressTokenComplete Function = InternalFunctionPrefix + "ProgressTokenComplete"
+
 	// FunctionStringFnComplete is an internal function used by govim to provide
 	// completion of arguments to CommandStringFn
 	FunctionStringFnComplete Function = InternalFunctionPrefix + "StringFnComplete"
 
+	// FunctionHierarchyToggle is an internal function used by govim to expand
+	// or collapse a node in a hierarchy buffer
+	FunctionHierarchyToggle Function = InternalFunctionPrefix + "HierarchyToggle"
+
+	// FunctionHierarchyJump is an internal function used by govim to jump to
+	// the location of a node in a hierarchy buffer
+	FunctionHierarchyJump Function = InternalFunctionPrefix + "HierarchyJump"
+
+	// FunctionExpandSelection expands the current visual selection to the
+	// smallest syntactic node that encloses it. It is intended to be called
+	// from a visual mode mapping, and is mapped to + in visual mode in Go
+	// buffers by default.
+	FunctionExpandSelection Function = "ExpandSelection"
+
+	// FunctionShrinkSelection shrinks the current visual selection to the one
+	// from which it was last expanded via FunctionExpandSelection in the
+	// current window. It is intended to be called from a visual mode mapping,
+	// and is mapped to - in visual mode in Go buffers by default.
+	FunctionShrinkSelection Function = "ShrinkSelection"
+
+	// FunctionSnippetNext jumps to the next placeholder of the snippet most
+	// recently inserted by completion, see CompletionSnippets. Placeholders
+	// with text are selected in select mode, such that typing replaces them.
+	// Jumping to the final placeholder ends the snippet. It is intended to be
+	// called from a normal mode mapping.
+	FunctionSnippetNext Function = "SnippetNext"
+
+	// FunctionSnippetPrev jumps to the previous placeholder of the snippet most
+	// recently inserted by completion, see FunctionSnippetNext.
+	FunctionSnippetPrev Function = "SnippetPrev"
+
+	// FunctionSignatureHelpCycle shows the next of the signatures in the
+	// popup shown while typing the arguments of a call, see
+	// SignatureHelpAuto.
+	FunctionSignatureHelpCycle Function = "SignatureHelpCycle"
+
 	// FunctionMotion moves the cursor according to the arguments provided.
 	FunctionMotion Function = "Motion"
 
@@ -569,6 +884,39 @@ const (
 	SymbolStyleDynamic SymbolStyle = "dynamic"
 )
 
+// BuildConfig is a build configuration under which code is diagnosed, see
+// Config.ExtraBuildConfigs. Values that are not set are as for the main
+// configuration.
+type BuildConfig struct {
+	// GOOS is the target operating system
+	GOOS string `json:",omitempty"`
+
+	// GOARCH is the target architecture
+	GOARCH string `json:",omitempty"`
+
+	// Tags are the build tags that are set, i.e. -tags
+	Tags []string `json:",omitempty"`
+}
+
+// String returns the name of the build configuration used to prefix the
+// diagnostics reported for it, e.g. "windows/amd64" or "darwin [integration]".
+func (b BuildConfig) String() string {
+	var parts []string
+	var target []string
+	for _, v := range []string{b.GOOS, b.GOARCH} {
+		if v != "" {
+			target = append(target, v)
+		}
+	}
+	if len(target) > 0 {
+		parts = append(parts, strings.Join(target, "/"))
+	}
+	if len(b.Tags) > 0 {
+		parts = append(parts, "["+strings.Join(b.Tags, ",")+"]")
+	}
+	return strings.Join(parts, " ")
+}
+
 // GoplsMemoryMode typed constants defined the set of valid values that
 // Config.GoplsMemoryMode can take
 type GoplsMemoryMode string
@@ -607,6 +955,15 @@ const (
 	HighlightSignInfo Highlight = "GOVIMSignInfo"
 	// HighlightSignHint is the group used to add hint signs in the gutter
 	HighlightSignHint Highlight = "GOVIMSignHint"
+	// HighlightSignTestPass is the group used to add signs in the gutter for
+	// passing tests, see CommandRunTests
+	HighlightSignTestPass Highlight = "GOVIMSignTestPass"
+	// HighlightSignTestFail is the group used to add signs in the gutter for
+	// failing tests, see CommandRunTests
+	HighlightSignTestFail Highlight = "GOVIMSignTestFail"
+	// HighlightSignTestSkip is the group used to add signs in the gutter for
+	// skipped tests, see CommandRunTests
+	HighlightSignTestSkip Highlight = "GOVIMSignTestSkip"
 
 	// HighlightHoverErr is ths group used to add errors to the hover popup
 	HighlightHoverErr Highlight = "GOVIMHoverErr"
@@ -628,6 +985,54 @@ const (
 	// HighlightSignatureParam is the group used to add text properties to the signature active parameter
 	HighlightSignatureParam Highlight = "GOVIMSignatureParam"
 
+	// HighlightInlayHint is the group used to add inlay hints as virtual text
+	HighlightInlayHint Highlight = "GOVIMInlayHint"
+
+	// HighlightVulncheck is the group used to annotate the require lines of
+	// vulnerable modules in go.mod files, see CommandVulncheck
+	HighlightVulncheck Highlight = "GOVIMVulncheck"
+
+	// HighlightCovered is the group used to highlight blocks covered by tests,
+	// see CommandCoverage
+	HighlightCovered Highlight = "GOVIMCovered"
+	// HighlightUncovered is the group used to highlight blocks not covered by
+	// tests, see CommandCoverage
+	HighlightUncovered Highlight = "GOVIMUncovered"
+	// HighlightCoverageSummary is the group used to add the coverage of a file
+	// as virtual text, see CommandCoverage
+	HighlightCoverageSummary Highlight = "GOVIMCoverageSummary"
+
+	// HighlightSnippetPlaceholder is the group used to highlight the
+	// placeholders of a snippet inserted by completion, see
+	// CompletionSnippets
+	HighlightSnippetPlaceholder Highlight = "GOVIMSnippetPlaceholder"
+
+	// HighlightCompletionDeprecated is the group used to strike through
+	// deprecated completion candidates in the completion menu. Versions of Vim
+	// prior to v9.1.1000 do not support this, in which case the first line of
+	// the info popup of deprecated candidates is struck through instead
+	HighlightCompletionDeprecated Highlight = "GOVIMCompletionDeprecated"
+
+	// HighlightSemanticNamespace is the group used to highlight package names
+	HighlightSemanticNamespace Highlight = "GOVIMSemanticNamespace"
+	// HighlightSemanticType is the group used to highlight types
+	HighlightSemanticType Highlight = "GOVIMSemanticType"
+	// HighlightSemanticInterface is the group used to highlight interface types
+	HighlightSemanticInterface Highlight = "GOVIMSemanticInterface"
+	// HighlightSemanticTypeParameter is the group used to highlight type parameters
+	HighlightSemanticTypeParameter Highlight = "GOVIMSemanticTypeParameter"
+	// HighlightSemanticParameter is the group used to highlight function parameters
+	HighlightSemanticParameter Highlight = "GOVIMSemanticParameter"
+	// HighlightSemanticVariable is the group used to highlight mutable variables
+	HighlightSemanticVariable Highlight = "GOVIMSemanticVariable"
+	// HighlightSemanticReadonlyVariable is the group used to highlight readonly
+	// variables, i.e. constants
+	HighlightSemanticReadonlyVariable Highlight = "GOVIMSemanticReadonlyVariable"
+	// HighlightSemanticFunction is the group used to highlight functions
+	HighlightSemanticFunction Highlight = "GOVIMSemanticFunction"
+	// HighlightSemanticMethod is the group used to highlight methods
+	HighlightSemanticMethod Highlight = "GOVIMSemanticMethod"
+
 	// HighlightGoTestPass
 	HighlightGoTestPass Highlight = "GOVIMGoTestPass"
 	//  HighlightGoTestFail
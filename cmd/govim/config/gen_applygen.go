@@ -16,6 +16,9 @@ func (r *Config) Apply(v *Config) {
 	if v.HighlightReferences != nil {
 		r.HighlightReferences = v.HighlightReferences
 	}
+	if v.HighlightSemanticTokens != nil {
+		r.HighlightSemanticTokens = v.HighlightSemanticTokens
+	}
 	if v.HoverDiagnostics != nil {
 		r.HoverDiagnostics = v.HoverDiagnostics
 	}
@@ -25,6 +28,21 @@ func (r *Config) Apply(v *Config) {
 	if v.CompletionMatcher != nil {
 		r.CompletionMatcher = v.CompletionMatcher
 	}
+	if v.CompletionSnippets != nil {
+		r.CompletionSnippets = v.CompletionSnippets
+	}
+	if v.CompletionAsync != nil {
+		r.CompletionAsync = v.CompletionAsync
+	}
+	if v.CompletionLazyDocumentation != nil {
+		r.CompletionLazyDocumentation = v.CompletionLazyDocumentation
+	}
+	if v.CompletionKindIcons != nil {
+		r.CompletionKindIcons = v.CompletionKindIcons
+	}
+	if v.SignatureHelpAuto != nil {
+		r.SignatureHelpAuto = v.SignatureHelpAuto
+	}
 	if v.SymbolMatcher != nil {
 		r.SymbolMatcher = v.SymbolMatcher
 	}
@@ -52,12 +70,30 @@ func (r *Config) Apply(v *Config) {
 	if v.GoplsDirectoryFilters != nil {
 		r.GoplsDirectoryFilters = v.GoplsDirectoryFilters
 	}
+	if v.ExtraBuildConfigs != nil {
+		r.ExtraBuildConfigs = v.ExtraBuildConfigs
+	}
 	if v.Analyses != nil {
 		r.Analyses = v.Analyses
 	}
+	if v.InlayHints != nil {
+		r.InlayHints = v.InlayHints
+	}
+	if v.Folding != nil {
+		r.Folding = v.Folding
+	}
 	if v.OpenLastProgressWith != nil {
 		r.OpenLastProgressWith = v.OpenLastProgressWith
 	}
+	if v.OpenExternalWith != nil {
+		r.OpenExternalWith = v.OpenExternalWith
+	}
+	if v.GoplsRemote != nil {
+		r.GoplsRemote = v.GoplsRemote
+	}
+	if v.VulncheckDB != nil {
+		r.VulncheckDB = v.VulncheckDB
+	}
 	if v.Gofumpt != nil {
 		r.Gofumpt = v.Gofumpt
 	}
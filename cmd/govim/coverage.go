@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/types"
+	"golang.org/x/tools/cover"
+)
+
+// coverage is the test coverage of a package, as shown by CommandCoverage.
+type coverage struct {
+	dir string
+
+	// files are the coverage profiles of the files of the package, keyed by
+	// filename. Files are removed as their coverage is invalidated by changes.
+	files map[string]*cover.Profile
+}
+
+// toggleCoverage toggles the coverage of the package of the current buffer.
+// When enabled, go test is run in the background, the results being shown by
+// applyCoverage.
+func (v *vimstate) toggleCoverage(flags govim.CommandFlags, args ...string) error {
+	if v.coverage != nil || v.cancelCoverage != nil {
+		v.clearCoverage()
+		return nil
+	}
+	b, _, err := v.bufCursorPos()
+	if err != nil {
+		return fmt.Errorf("failed to get cursor position: %v", err)
+	}
+	dir := filepath.Dir(b.Name)
+
+	f, err := os.CreateTemp("", "govim-coverage-*.out")
+	if err != nil {
+		return fmt.Errorf("failed to create coverage profile: %v", err)
+	}
+	profile := f.Name()
+	f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancelCoverage = cancel
+	cmd := exec.CommandContext(ctx, "go", "test", "-coverprofile="+profile, ".")
+	cmd.Dir = dir
+	cmd.Env = v.goCommandEnv()
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	v.Logf("running coverage in %v: %v", dir, strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		cancel()
+		v.cancelCoverage = nil
+		os.Remove(profile)
+		return fmt.Errorf("failed to run %v: %v", strings.Join(cmd.Args, " "), err)
+	}
+	v.ChannelEx(`echom "Running tests for coverage..."`)
+
+	v.tomb.Go(func() error {
+		// Failing tests still produce a profile, hence the error from go test
+		// only matters if there is no profile
+		werr := cmd.Wait()
+		profiles, err := cover.ParseProfiles(profile)
+		os.Remove(profile)
+		if err == nil && len(profiles) == 0 && werr != nil {
+			err = fmt.Errorf("go test failed: %v\n%s", werr, bytes.TrimSpace(out.Bytes()))
+		}
+		v.govimplugin.Schedule(func(govim.Govim) error {
+			select {
+			case <-ctx.Done():
+				// Toggled off before the results arrived
+				return nil
+			default:
+			}
+			cancel()
+			v.cancelCoverage = nil
+			if err != nil {
+				err = fmt.Errorf("failed to get coverage: %v", err)
+				v.Logf("%v", err)
+				v.showMessage("ErrorMsg", err.Error())
+				return nil
+			}
+			v.applyCoverage(dir, profiles)
+			return nil
+		})
+		return nil
+	})
+	return nil
+}
+
+// applyCoverage shows the coverage profiles of the package in dir in all
+// open buffers of the package, and echoes a summary per file.
+func (v *vimstate) applyCoverage(dir string, profiles []*cover.Profile) {
+	c := &coverage{
+		dir:   dir,
+		files: make(map[string]*cover.Profile),
+	}
+	var summary []string
+	for _, p := range profiles {
+		// Profiles refer to files by import path, and all files belong to
+		// the package in dir
+		fn := filepath.Join(dir, path.Base(p.FileName))
+		c.files[fn] = p
+		summary = append(summary, fmt.Sprintf("%v %.1f%%", filepath.Base(fn), coveragePercent(p)))
+	}
+	sort.Strings(summary)
+	v.coverage = c
+	for _, b := range v.buffers {
+		v.showCoverage(b)
+	}
+	v.ChannelExf("echom %q", "Coverage: "+strings.Join(summary, ", "))
+}
+
+// coveragePercent returns the percentage of statements covered in p.
+func coveragePercent(p *cover.Profile) float64 {
+	var total, covered int
+	for _, b := range p.Blocks {
+		total += b.NumStmt
+		if b.Count > 0 {
+			covered += b.NumStmt
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// showCoverage adds text properties for the coverage blocks of b, if b is a
+// file with coverage. The percentage of statements covered is shown as
+// virtual text at the end of the first line.
+func (v *vimstate) showCoverage(b *types.Buffer) {
+	c := v.coverage
+	if c == nil || !b.Loaded {
+		return
+	}
+	p, ok := c.files[b.Name]
+	if !ok {
+		return
+	}
+	v.BatchStart()
+	defer v.BatchCancelIfNotEnded()
+	v.removeBufferCoverage(b)
+	for _, block := range p.Blocks {
+		hi := config.HighlightCovered
+		if block.Count == 0 {
+			hi = config.HighlightUncovered
+		}
+		v.BatchAssertChannelCall(assertPropAdd, "prop_add", block.StartLine, block.StartCol, propAddDict{
+			Type:    string(hi),
+			ID:      types.CoverageTextPropID,
+			EndLine: block.EndLine,
+			EndCol:  block.EndCol,
+			BufNr:   b.Num,
+		})
+	}
+	if v.hasVirtualText {
+		if l, err := b.Line(1); err == nil {
+			text := fmt.Sprintf("  coverage: %.1f%% of statements", coveragePercent(p))
+			v.BatchAssertChannelCall(assertPropAdd, "prop_add", 1, len(l)+1,
+				propAddTextDict{string(config.HighlightCoverageSummary), text, b.Num},
+			)
+		}
+	}
+	v.MustBatchEnd()
+}
+
+// removeBufferCoverage removes the coverage text properties from b.
+func (v *vimstate) removeBufferCoverage(b *types.Buffer) {
+	var didStart bool
+	if didStart = v.BatchStartIfNeeded(); didStart {
+		defer v.BatchCancelIfNotEnded()
+	}
+	for _, hi := range []config.Highlight{
+		config.HighlightCovered,
+		config.HighlightUncovered,
+		config.HighlightCoverageSummary,
+	} {
+		v.BatchChannelCall("prop_remove", struct {
+			Type  string `json:"type"`
+			BufNr int    `json:"bufnr"`
+			All   int    `json:"all"`
+		}{string(hi), b.Num, 1})
+	}
+	if didStart {
+		v.MustBatchEnd()
+	}
+}
+
+// clearCoverage removes the coverage from all buffers, and cancels any
+// ongoing run of go test for coverage.
+func (v *vimstate) clearCoverage() {
+	if v.cancelCoverage != nil {
+		v.cancelCoverage()
+		v.cancelCoverage = nil
+	}
+	c := v.coverage
+	v.coverage = nil
+	if c == nil {
+		return
+	}
+	v.BatchStart()
+	defer v.BatchCancelIfNotEnded()
+	for _, b := range v.buffers {
+		if _, ok := c.files[b.Name]; ok && b.Loaded {
+			v.removeBufferCoverage(b)
+		}
+	}
+	v.MustBatchEnd()
+}
+
+// invalidateCoverage removes the coverage of b if any of changes touch one of
+// its coverage blocks, because the coverage of the block is then unknown.
+// Otherwise the blocks are moved according to changes, such that they remain
+// in line with the text properties that Vim moves.
+func (v *vimstate) invalidateCoverage(b *types.Buffer, changes []bufChangedChange) {
+	c := v.coverage
+	if c == nil {
+		return
+	}
+	p, ok := c.files[b.Name]
+	if !ok {
+		return
+	}
+	for _, ch := range changes {
+		// Lines [lnum, end) have been replaced, with added being the change
+		// in the number of lines. If no lines have been replaced, lines have
+		// been inserted before lnum.
+		lnum, end := int(ch.Lnum), int(ch.End)
+		for i := range p.Blocks {
+			block := &p.Blocks[i]
+			touched := block.StartLine < end && block.EndLine >= lnum
+			if lnum == end {
+				touched = block.StartLine < lnum && block.EndLine >= lnum
+			}
+			if touched {
+				v.Logf("coverage of %v invalidated by change", b.Name)
+				delete(c.files, b.Name)
+				v.removeBufferCoverage(b)
+				return
+			}
+			if block.StartLine >= end {
+				block.StartLine += ch.Added
+				block.EndLine += ch.Added
+			}
+		}
+	}
+}
@@ -24,6 +24,22 @@ func (v *vimstate) diagnostics() *[]types.Diagnostic {
 	for k, v := range v.rawDiagnostics {
 		filediags[k] = v.Diagnostics
 	}
+	// Diagnostics for extra build configurations are prefixed with the name
+	// of the configuration, unless they are also reported for the main
+	// configuration.
+	for _, b := range v.buildConfigs {
+		for k, params := range b.diagnostics {
+			for _, d := range params.Diagnostics {
+				if containsDiagnostic(filediags[k], d) {
+					continue
+				}
+				d.Message = b.name + ": " + d.Message
+				// Do not append to the backing array of v.rawDiagnostics
+				fd := filediags[k]
+				filediags[k] = append(fd[:len(fd):len(fd)], d)
+			}
+		}
+	}
 	v.diagnosticsChanged = false
 	v.diagnosticsChangedLock.Unlock()
 
@@ -85,6 +101,17 @@ func (v *vimstate) diagnostics() *[]types.Diagnostic {
 	return v.diagnosticsCache
 }
 
+// containsDiagnostic reports whether diags contains a diagnostic with the
+// same range, severity and message as d.
+func containsDiagnostic(diags []protocol.Diagnostic, d protocol.Diagnostic) bool {
+	for _, e := range diags {
+		if e.Range == d.Range && e.Severity == d.Severity && e.Message == d.Message {
+			return true
+		}
+	}
+	return false
+}
+
 func (v *vimstate) handleDiagnosticsChanged() error {
 	if err := v.updateQuickfixWithDiagnostics(false); err != nil {
 		return err
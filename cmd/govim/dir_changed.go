@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// dirChanged handles a change of Vim's global current directory, re-rooting
+// govim in the go.work or go.mod of the new directory. Buffers, and the
+// diagnostics for them, are unaffected.
+func (v *vimstate) dirChanged(args ...json.RawMessage) error {
+	wd := v.ParseString(args[0])
+	if wd == v.workingDirectory {
+		return nil
+	}
+	v.workingDirectory = wd
+
+	gomodspec, err := goModSpecPath(wd)
+	if err != nil {
+		return fmt.Errorf("failed to derive go.work/go.mod path: %v", err)
+	}
+	if gomodspec != "" && gomodspec != os.DevNull {
+		// i.e. we are in a module or a workspace. The file watchers follow
+		// the workspace folders.
+		v.setRootWorkspaceFolder(filepath.Dir(gomodspec))
+	}
+
+	// Quickfix entries are relative to the working directory, so force them to
+	// be recalculated
+	v.lastDiagnosticsQuickfix = nil
+	return v.updateQuickfixWithDiagnostics(false)
+}
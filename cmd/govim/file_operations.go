@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/span"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+	"golang.org/x/mod/modfile"
+)
+
+// resourceOperationKinds are the kinds of resource operation in workspace
+// edits that we support.
+var resourceOperationKinds = []protocol.ResourceOperationKind{protocol.Create, protocol.Rename, protocol.Delete}
+
+// documentChange is a change in a workspace edit: either a text document edit
+// or a resource operation. protocol.DocumentChanges decodes every change that
+// is not a text document edit as a RenameFile, losing the URI of create and
+// delete operations, so workspace/applyEdit requests from gopls are decoded
+// via documentChange instead, see applyEditHandler. gopls only returns text
+// document edits and renames in the results of other requests, which are
+// decoded by the protocol package.
+type documentChange struct {
+	TextDocumentEdit *protocol.TextDocumentEdit
+	CreateFile       *protocol.CreateFile
+	RenameFile       *protocol.RenameFile
+	DeleteFile       *protocol.DeleteFile
+}
+
+func (d *documentChange) UnmarshalJSON(data []byte) error {
+	var change struct {
+		Kind         string          `json:"kind"`
+		TextDocument json.RawMessage `json:"textDocument"`
+	}
+	if err := json.Unmarshal(data, &change); err != nil {
+		return err
+	}
+	var v interface{}
+	switch {
+	case change.TextDocument != nil:
+		d.TextDocumentEdit = new(protocol.TextDocumentEdit)
+		v = d.TextDocumentEdit
+	case change.Kind == string(protocol.Create):
+		d.CreateFile = new(protocol.CreateFile)
+		v = d.CreateFile
+	case change.Kind == string(protocol.Rename):
+		d.RenameFile = new(protocol.RenameFile)
+		v = d.RenameFile
+	case change.Kind == string(protocol.Delete):
+		d.DeleteFile = new(protocol.DeleteFile)
+		v = d.DeleteFile
+	default:
+		return fmt.Errorf("unknown kind of document change %q", change.Kind)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// documentChanges converts changes decoded by the protocol package.
+func documentChanges(changes []protocol.DocumentChanges) []documentChange {
+	res := make([]documentChange, len(changes))
+	for i, c := range changes {
+		res[i] = documentChange{TextDocumentEdit: c.TextDocumentEdit, RenameFile: c.RenameFile}
+	}
+	return res
+}
+
+// applyWorkspaceEditParams are the parameters of a workspace/applyEdit
+// request, i.e. protocol.ApplyWorkspaceEditParams with the document changes
+// decoded via documentChange.
+type applyWorkspaceEditParams struct {
+	Label string `json:"label,omitempty"`
+	Edit  struct {
+		DocumentChanges []documentChange `json:"documentChanges,omitempty"`
+	} `json:"edit"`
+}
+
+// applyResourceOperation applies the non-text document change c of a
+// workspace edit.
+func (v *vimstate) applyResourceOperation(c documentChange) error {
+	switch {
+	case c.CreateFile != nil:
+		return v.createFile(c.CreateFile.URI, c.CreateFile.Options)
+	case c.RenameFile != nil:
+		if c.RenameFile.Kind != string(protocol.Rename) {
+			// The change was decoded by the protocol package, see
+			// documentChange
+			return fmt.Errorf("unsupported %q resource operation in workspace edit", c.RenameFile.Kind)
+		}
+		return v.renameFile(c.RenameFile.OldURI, c.RenameFile.NewURI, c.RenameFile.Options)
+	case c.DeleteFile != nil:
+		return v.deleteFile(c.DeleteFile.URI, c.DeleteFile.Options)
+	}
+	return fmt.Errorf("empty document change in workspace edit")
+}
+
+// createFile creates the empty file uri. As with any other change on disk,
+// gopls learns of the new file via the file watcher.
+func (v *vimstate) createFile(uri protocol.DocumentURI, opts protocol.CreateFileOptions) error {
+	path := uri.SpanURI().Filename()
+	if _, err := os.Stat(path); err == nil && !opts.Overwrite {
+		if opts.IgnoreIfExists {
+			return nil
+		}
+		return fmt.Errorf("cannot create %v: file already exists", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return fmt.Errorf("failed to create directory for %v: %v", path, err)
+	}
+	if err := os.WriteFile(path, nil, 0666); err != nil {
+		return fmt.Errorf("failed to create %v: %v", path, err)
+	}
+	return nil
+}
+
+// deleteFile deletes the file or directory uri, wiping any buffers for files
+// that are deleted as a result. As with any other change on disk, gopls
+// learns of the deletion via the file watcher.
+func (v *vimstate) deleteFile(uri protocol.DocumentURI, opts protocol.DeleteFileOptions) error {
+	path := uri.SpanURI().Filename()
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) && opts.IgnoreIfNotExists {
+			return nil
+		}
+		return fmt.Errorf("cannot delete %v: %v", path, err)
+	}
+	if fi.IsDir() && opts.Recursive {
+		err = os.RemoveAll(path)
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete %v: %v", path, err)
+	}
+
+	// Sort by buffer number so that we have reproducible behaviour
+	var deleted []*types.Buffer
+	for _, b := range v.buffers {
+		if b.Name == path || strings.HasPrefix(b.Name, path+string(filepath.Separator)) {
+			deleted = append(deleted, b)
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].Num < deleted[j].Num
+	})
+	for _, b := range deleted {
+		if err := v.deleteBuffer(b); err != nil {
+			return err
+		}
+		v.ChannelExf("noautocmd bwipeout! %v", b.Num)
+	}
+	return nil
+}
+
+// renameFile renames the file or directory oldURI to newURI on disk, moving
+// any buffers for files that are renamed as a result, and notifies gopls of
+// the rename.
+func (v *vimstate) renameFile(oldURI, newURI protocol.DocumentURI, opts protocol.RenameFileOptions) error {
+	oldPath := oldURI.SpanURI().Filename()
+	newPath := newURI.SpanURI().Filename()
+	if _, err := os.Stat(newPath); err == nil && !opts.Overwrite {
+		if opts.IgnoreIfExists {
+			return nil
+		}
+		return fmt.Errorf("cannot rename %v to %v: target already exists", oldPath, newPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0777); err != nil {
+		return fmt.Errorf("failed to create directory for %v: %v", newPath, err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to rename %v to %v: %v", oldPath, newPath, err)
+	}
+
+	for _, b := range v.movedBuffers(oldPath) {
+		if err := v.renameBuffer(b, newPath+strings.TrimPrefix(b.Name, oldPath)); err != nil {
+			return err
+		}
+	}
+
+	v.didRenameFile(oldURI, newURI)
+	return nil
+}
+
+// movedBuffers returns the buffers that move when the file or directory
+// oldPath is renamed: when renaming a directory, every buffer for a file
+// within it moves. They are sorted by buffer number so that we have
+// reproducible behaviour.
+func (v *vimstate) movedBuffers(oldPath string) []*types.Buffer {
+	var moved []*types.Buffer
+	for _, b := range v.buffers {
+		if b.Name == oldPath || strings.HasPrefix(b.Name, oldPath+string(filepath.Separator)) {
+			moved = append(moved, b)
+		}
+	}
+	sort.Slice(moved, func(i, j int) bool {
+		return moved[i].Num < moved[j].Num
+	})
+	return moved
+}
+
+// checkRenamesUnsaved returns an error if a buffer that moves as a result of
+// a rename in changes has unsaved changes. Moving a buffer writes it under
+// its new name, which must not write the user's changes behind their back.
+// It must therefore be called before any text edits in changes are applied,
+// those edits being written along with the rename.
+func (v *vimstate) checkRenamesUnsaved(changes []documentChange) error {
+	for _, c := range changes {
+		if c.RenameFile == nil {
+			continue
+		}
+		oldPath := c.RenameFile.OldURI.SpanURI().Filename()
+		for _, b := range v.movedBuffers(oldPath) {
+			if b.Loaded && v.ParseInt(v.ChannelCall("getbufvar", b.Num, "&modified")) != 0 {
+				return fmt.Errorf("cannot rename %v to %v: %v has unsaved changes", oldPath, c.RenameFile.NewURI.SpanURI().Filename(), b.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// willRenameFile asks gopls for the changes required (e.g. to imports) as a
+// result of renaming the file or directory oldURI to newURI. The changes must
+// be applied before the rename. If gopls does not implement WillRenameFiles we
+// fall back to updating import paths ourselves, see importPathChanges.
+func (v *vimstate) willRenameFile(oldURI, newURI protocol.DocumentURI) []protocol.DocumentChanges {
+	oldPath := oldURI.SpanURI().Filename()
+	if len(v.goplsCapabilities.Workspace.FileOperations.WillRename.Filters) == 0 {
+		changes, err := v.importPathChanges(oldPath, newURI.SpanURI().Filename())
+		if err != nil {
+			v.showMessage("WarningMsg", fmt.Sprintf("Imports have not been updated for the move of %v: %v", oldPath, err))
+			return nil
+		}
+		return changes
+	}
+	params := &protocol.RenameFilesParams{
+		Files: []protocol.FileRename{{OldURI: string(oldURI), NewURI: string(newURI)}},
+	}
+	edit, err := v.server.WillRenameFiles(context.Background(), params)
+	if err != nil {
+		// The rename can still go ahead, just without any changes. Tell the
+		// user, because imports and references will be left broken.
+		v.showMessage("WarningMsg", fmt.Sprintf("Imports and references have not been updated for the move of %v: %v", oldPath, err))
+		return nil
+	}
+	if edit == nil {
+		return nil
+	}
+	return edit.DocumentChanges
+}
+
+// importPathChanges returns the changes to imports in the Go files of the
+// workspace required as a result of renaming the file or directory oldPath to
+// newPath. Only moves that change the import path of a package require any
+// changes: that of a directory, or that of the last (non-test) Go file of a
+// package to another directory. References to the package need no changes
+// because its name is unchanged.
+func (v *vimstate) importPathChanges(oldPath, newPath string) ([]protocol.DocumentChanges, error) {
+	fi, err := os.Stat(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	oldDir, newDir := oldPath, newPath
+	if !fi.IsDir() {
+		oldDir, newDir = filepath.Dir(oldPath), filepath.Dir(newPath)
+		if !isPackageFile(oldPath) || oldDir == newDir {
+			return nil, nil
+		}
+		entries, err := os.ReadDir(oldDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			fn := filepath.Join(oldDir, e.Name())
+			if fn != oldPath && !e.IsDir() && isPackageFile(fn) {
+				// The package stays where it is
+				return nil, nil
+			}
+		}
+	}
+	oldImport, newImport := dirImportPath(oldDir), dirImportPath(newDir)
+	if oldImport == "" || newImport == "" || oldImport == newImport {
+		return nil, nil
+	}
+	// When a directory moves, so do the packages within it
+	matches := func(p string) bool {
+		return p == oldImport || fi.IsDir() && strings.HasPrefix(p, oldImport+"/")
+	}
+
+	var res []protocol.DocumentChanges
+	for _, folder := range v.workspaceFolders {
+		err := filepath.WalkDir(folder, func(fn string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				// Skip the directories the go command ignores
+				n := d.Name()
+				if fn != folder && (strings.HasPrefix(n, ".") || strings.HasPrefix(n, "_") || n == "vendor" || n == "testdata") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(fn) != ".go" {
+				return nil
+			}
+			b, err := v.uriBuffer(span.URIFromPath(fn))
+			if err != nil {
+				return err
+			}
+			fset := token.NewFileSet()
+			f, err := parser.ParseFile(fset, fn, b.Contents(), parser.ImportsOnly)
+			if err != nil {
+				// We can't fix what we can't parse
+				return nil
+			}
+			var edits []protocol.TextEdit
+			for _, imp := range f.Imports {
+				p, err := strconv.Unquote(imp.Path.Value)
+				if err != nil || !matches(p) {
+					continue
+				}
+				start, err := types.PointFromOffset(b, fset.Position(imp.Path.Pos()).Offset)
+				if err != nil {
+					return err
+				}
+				end, err := types.PointFromOffset(b, fset.Position(imp.Path.End()).Offset)
+				if err != nil {
+					return err
+				}
+				edits = append(edits, protocol.TextEdit{
+					Range:   protocol.Range{Start: start.ToPosition(), End: end.ToPosition()},
+					NewText: strconv.Quote(newImport + strings.TrimPrefix(p, oldImport)),
+				})
+			}
+			if len(edits) > 0 {
+				res = append(res, protocol.DocumentChanges{TextDocumentEdit: &protocol.TextDocumentEdit{
+					TextDocument: protocol.OptionalVersionedTextDocumentIdentifier{
+						Version:                b.Version,
+						TextDocumentIdentifier: b.ToTextDocumentIdentifier(),
+					},
+					Edits: edits,
+				}})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// isPackageFile reports whether fn is a non-test Go file.
+func isPackageFile(fn string) bool {
+	return filepath.Ext(fn) == ".go" && !strings.HasSuffix(fn, "_test.go")
+}
+
+// dirImportPath returns the import path of the package in directory dir,
+// which need not exist, according to the go.mod file of the enclosing module.
+// It returns "" if dir is not within a module.
+func dirImportPath(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		byts, err := os.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			mp := modfile.ModulePath(byts)
+			rel, err := filepath.Rel(d, dir)
+			if mp == "" || err != nil {
+				return ""
+			}
+			return path.Join(mp, filepath.ToSlash(rel))
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+// didRenameFile notifies gopls that the file or directory oldURI has been
+// renamed to newURI.
+func (v *vimstate) didRenameFile(oldURI, newURI protocol.DocumentURI) {
+	params := &protocol.RenameFilesParams{
+		Files: []protocol.FileRename{{OldURI: string(oldURI), NewURI: string(newURI)}},
+	}
+	if len(v.goplsCapabilities.Workspace.FileOperations.DidRename.Filters) == 0 {
+		// gopls does not (yet) implement DidRenameFiles, and the
+		// DidClose/DidOpen sent for the buffers we moved will have told it all
+		// it needs to know.
+		return
+	}
+	if err := v.server.DidRenameFiles(context.Background(), params); err != nil {
+		v.Logf("failed to call gopls.DidRenameFiles: %v", err)
+	}
+}
+
+func (v *vimstate) moveFile(flags govim.CommandFlags, args ...string) error {
+	var src, dst string
+	switch len(args) {
+	case 1:
+		b, _, err := v.bufCursorPos()
+		if err != nil {
+			return fmt.Errorf("failed to determine file to move: %v", err)
+		}
+		src, dst = b.Name, args[0]
+	case 2:
+		src, dst = args[0], args[1]
+	default:
+		return fmt.Errorf("expected one or two arguments; got %v", len(args))
+	}
+	// Resolve paths as Vim would, i.e. relative to its current directory
+	src = filepath.Clean(v.ParseString(v.ChannelCall("fnamemodify", src, ":p")))
+	dst = filepath.Clean(v.ParseString(v.ChannelCall("fnamemodify", dst, ":p")))
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		// As with mv, moving to a directory moves into it
+		dst = filepath.Join(dst, filepath.Base(src))
+	}
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("cannot move %v: %v", src, err)
+	}
+	oldURI := protocol.DocumentURI(span.URIFromPath(src))
+	newURI := protocol.DocumentURI(span.URIFromPath(dst))
+
+	// The changes are computed before the move, but only applied once the
+	// buffers have been moved, such that moving a buffer does not write them.
+	rename := documentChange{RenameFile: &protocol.RenameFile{Kind: string(protocol.Rename), OldURI: oldURI, NewURI: newURI}}
+	if err := v.checkRenamesUnsaved([]documentChange{rename}); err != nil {
+		return err
+	}
+	changes := v.willRenameFile(oldURI, newURI)
+	if err := v.renameFile(oldURI, newURI, protocol.RenameFileOptions{}); err != nil {
+		return err
+	}
+	renamedChanges(changes, src, dst)
+	if err := v.applyMultiBufTextedits(flags.Mods, changes); err != nil {
+		return fmt.Errorf("failed to apply changes for move of %v: %v", src, err)
+	}
+	return nil
+}
+
+// renamedChanges updates changes, computed for the rename of the file or
+// directory oldPath to newPath, to refer to files by their names after the
+// rename.
+func renamedChanges(changes []protocol.DocumentChanges, oldPath, newPath string) {
+	for _, c := range changes {
+		if c.TextDocumentEdit == nil {
+			continue
+		}
+		path := c.TextDocumentEdit.TextDocument.URI.SpanURI().Filename()
+		if path == oldPath || strings.HasPrefix(path, oldPath+string(filepath.Separator)) {
+			c.TextDocumentEdit.TextDocument.URI = protocol.DocumentURI(span.URIFromPath(newPath + strings.TrimPrefix(path, oldPath)))
+		}
+	}
+}
+
+// bufFilePost handles a tracked buffer being given a new file name by the
+// user. Via :file, we treat that as a move of the file. Via :saveas, the
+// buffer is a copy of the file under its new name, and the original is left
+// as is; the third argument reports whether that is the case.
+func (v *vimstate) bufFilePost(args ...json.RawMessage) error {
+	bufnr := v.ParseInt(args[0])
+	name := v.ParseString(args[1])
+	copied := v.ParseInt(args[2]) != 0
+	b, ok := v.buffers[bufnr]
+	if !ok || b.Name == name {
+		return nil
+	}
+	if copied {
+		return v.bufferRenamed(b, name)
+	}
+	oldURI := protocol.DocumentURI(b.URI())
+	newURI := protocol.DocumentURI(span.URIFromPath(name))
+	oldPath := b.Name
+	changes := v.willRenameFile(oldURI, newURI)
+	if err := v.bufferRenamed(b, name); err != nil {
+		return err
+	}
+	// Vim has already renamed the buffer, so any changes for the old file are
+	// applied to the buffer under its new name.
+	renamedChanges(changes, oldPath, name)
+	if err := v.applyMultiBufTextedits(nil, changes); err != nil {
+		return fmt.Errorf("failed to apply changes for rename of %v: %v", b.Name, err)
+	}
+	v.didRenameFile(oldURI, newURI)
+	return nil
+}
+
+// renameBuffer gives b the file name name, once the file behind b has been
+// moved on disk.
+func (v *vimstate) renameBuffer(b *types.Buffer, name string) error {
+	if !b.Loaded {
+		// Vim replaces an unloaded buffer with a new buffer for name, which we
+		// will start tracking if and when it is loaded.
+		if err := v.deleteBuffer(b); err != nil {
+			return err
+		}
+		v.ChannelExf("call s:renameBuffer(%v, %q)", b.Num, name)
+		return nil
+	}
+	v.ChannelExf("call s:renameBuffer(%v, %q)", b.Num, name)
+	return v.bufferRenamed(b, name)
+}
+
+// bufferRenamed updates our record of b, and gopls, once Vim has given b the
+// file name name.
+func (v *vimstate) bufferRenamed(b *types.Buffer, name string) error {
+	params := &protocol.DidCloseTextDocumentParams{
+		TextDocument: b.ToTextDocumentIdentifier(),
+	}
+	if err := v.server.DidClose(context.Background(), params); err != nil {
+		return fmt.Errorf("failed to call gopls.DidClose on %v: %v", b.Name, err)
+	}
+	b.Name = name
+	v.addWorkspaceFolderFor(b)
+	openParams := &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{
+			LanguageID: detectLanguage(b.URI().Filename()).String(),
+			URI:        protocol.DocumentURI(b.URI()),
+			Version:    b.Version,
+			Text:       string(b.Contents()),
+		},
+	}
+	if err := v.server.DidOpen(context.Background(), openParams); err != nil {
+		return fmt.Errorf("failed to call gopls.DidOpen on %v: %v", b.Name, err)
+	}
+	v.removeUnusedWorkspaceFolders()
+	return nil
+}
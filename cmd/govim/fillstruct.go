@@ -65,7 +65,7 @@ func (v *vimstate) fillStruct(flags govim.CommandFlags, args ...string) error {
 		// version. Let's go for the first one and let the user call fillstruct again if they
 		// want to fill several structs on the same line.
 		ca := codeActions[0]
-		_, ecErr = v.server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
+		_, ecErr = v.goplsServer().ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
 			Command:                ca.Command.Command,
 			Arguments:              ca.Command.Arguments,
 			WorkDoneProgressParams: protocol.WorkDoneProgressParams{},
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+// foldLevelsVar is the buffer-local variable that holds the fold level of each
+// line, as read by GOVIMFoldExpr
+const foldLevelsVar = "govim_foldlevels"
+
+// foldExpr is the value of foldexpr set in windows that show a Go buffer when
+// Folding is enabled
+const foldExpr = "GOVIMFoldExpr(v:lnum)"
+
+// bufferFolds are the fold levels of a version of a buffer.
+type bufferFolds struct {
+	// buf is the buffer the folds were computed for. We keep a reference to
+	// the buffer itself rather than relying on the buffer number in order to
+	// detect a buffer having been unloaded and reloaded.
+	buf *types.Buffer
+
+	version int32
+	levels  []string
+}
+
+// updateFolds requests the folding ranges of b from gopls, unless the folds
+// for the current version of b are already known. Once gopls responds the
+// fold levels are pushed to Vim, so that GOVIMFoldExpr never has to block on
+// govim.
+func (v *vimstate) updateFolds(b *types.Buffer) {
+	if v.config.Folding == nil || !*v.config.Folding || !b.Loaded {
+		return
+	}
+	if f, ok := v.folds[b.Num]; ok && f.buf == b && f.version == b.Version {
+		return
+	}
+	version := b.Version
+	lines := bytes.Count(b.Contents(), []byte("\n"))
+	v.tomb.Go(func() error {
+		v.redefineFolds(b, version, lines)
+		return nil
+	})
+}
+
+func (g *govimplugin) redefineFolds(b *types.Buffer, version int32, lines int) {
+	ranges, err := g.goplsServer().FoldingRange(context.Background(), &protocol.FoldingRangeParams{
+		TextDocument: b.ToTextDocumentIdentifier(),
+	})
+	if err != nil {
+		g.Logf("foldingRange call failed: %v", err)
+		return
+	}
+	levels := foldLevels(ranges, lines)
+	g.Schedule(func(govim.Govim) error {
+		v := g.vimstate
+		if v.config.Folding == nil || !*v.config.Folding {
+			return nil
+		}
+		if cb, ok := v.buffers[b.Num]; !ok || cb != b || b.Version != version {
+			// The buffer has changed (or gone) since the folding ranges were
+			// requested, in which case another request will have been made.
+			return nil
+		}
+		v.folds[b.Num] = &bufferFolds{buf: b, version: version, levels: levels}
+		v.applyFolds(b, levels)
+		return nil
+	})
+}
+
+// foldLevels returns the fold level of each of the lines of a buffer, in the
+// format expected by foldexpr, given the folding ranges of the buffer.
+func foldLevels(ranges []protocol.FoldingRange, lines int) []string {
+	depth := make([]int, lines)
+	starts := make([]bool, lines)
+	for _, r := range ranges {
+		start, end := int(r.StartLine), int(r.EndLine)
+		if end >= lines {
+			end = lines - 1
+		}
+		if start >= end {
+			// A single line cannot be folded
+			continue
+		}
+		starts[start] = true
+		for l := start; l <= end; l++ {
+			depth[l]++
+		}
+	}
+	levels := make([]string, lines)
+	for i, d := range depth {
+		if starts[i] {
+			// Mark the start of a fold, to distinguish adjacent folds
+			levels[i] = fmt.Sprintf(">%v", d)
+		} else {
+			levels[i] = fmt.Sprint(d)
+		}
+	}
+	return levels
+}
+
+// setFoldExpr sets foldexpr to GOVIMFoldExpr and foldmethod to expr in a
+// window, remembering the previous foldmethod of the window such that
+// removeFolds can restore it. It is run via win_execute.
+const setFoldExpr = "if &l:foldexpr !=# '" + foldExpr + "' | let w:govim_foldmethod = &l:foldmethod | endif | let &l:foldmethod = 'expr' | let &l:foldexpr = '" + foldExpr + "'"
+
+// unsetFoldExpr reverts setFoldExpr in a window. It is run via win_execute.
+// Vim keeps the folds computed via foldexpr as manual folds when foldmethod
+// changes to manual, so they are eliminated.
+const unsetFoldExpr = "if &l:foldexpr ==# '" + foldExpr + "' | setlocal foldexpr< | if exists('w:govim_foldmethod') | let &l:foldmethod = w:govim_foldmethod | unlet w:govim_foldmethod | if &l:foldmethod ==# 'manual' | silent! normal! zE | endif | endif | endif"
+
+// applyFolds sets the fold levels of b and sets foldexpr to GOVIMFoldExpr and
+// foldmethod to expr in any window that shows b. Setting foldexpr, even to
+// the same value, forces Vim to recompute the folds.
+func (v *vimstate) applyFolds(b *types.Buffer, levels []string) {
+	var didStart bool
+	if didStart = v.BatchStartIfNeeded(); didStart {
+		defer v.BatchCancelIfNotEnded()
+	}
+	v.BatchChannelCall("setbufvar", b.Num, foldLevelsVar, levels)
+	v.BatchChannelExprf(`map(win_findbuf(%v), {_, w -> win_execute(w, %q)})`, b.Num, setFoldExpr)
+	if didStart {
+		v.MustBatchEnd()
+	}
+}
+
+// showFolds is called when a window starts showing b. The known folds of b
+// are applied to windows that don't yet use them, and otherwise the folds of
+// b are requested.
+func (v *vimstate) showFolds(b *types.Buffer) {
+	if v.config.Folding == nil || !*v.config.Folding || !b.Loaded {
+		return
+	}
+	f, ok := v.folds[b.Num]
+	if !ok || f.buf != b || f.version != b.Version {
+		v.updateFolds(b)
+		return
+	}
+	v.ChannelExprf(`map(win_findbuf(%v), {_, w -> getwinvar(w, "&foldexpr") ==# %q ? 0 : win_execute(w, %q)})`, b.Num, foldExpr, setFoldExpr)
+}
+
+// removeFolds forgets the fold levels of all buffers and restores the global
+// value of foldexpr, and the previous foldmethod, in the windows where they
+// were set by applyFolds.
+func (v *vimstate) removeFolds() {
+	v.BatchStart()
+	defer v.BatchCancelIfNotEnded()
+	for _, f := range v.folds {
+		if !f.buf.Loaded {
+			continue
+		}
+		v.BatchChannelCall("setbufvar", f.buf.Num, foldLevelsVar, []string{})
+		v.BatchChannelExprf(`map(win_findbuf(%v), {_, w -> win_execute(w, %q)})`, f.buf.Num, unsetFoldExpr)
+	}
+	v.MustBatchEnd()
+	v.folds = make(map[int]*bufferFolds)
+}
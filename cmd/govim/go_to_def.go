@@ -65,6 +65,14 @@ func (v *vimstate) handleProtocolLocations(b *types.Buffer, pos types.CursorPosi
 	}
 
 	loc := locs[0]
+	v.pushJumpStack(b, pos)
+	return &loc, nil
+}
+
+// pushJumpStack records the position pos in buffer b as the location to
+// return to via CommandGoToPrevDef, discarding any entries above the current
+// position in the jump stack.
+func (v *vimstate) pushJumpStack(b *types.Buffer, pos types.CursorPosition) {
 	v.jumpStack = append(v.jumpStack[:v.jumpStackPos], protocol.Location{
 		URI: protocol.DocumentURI(b.URI()),
 		Range: protocol.Range{
@@ -73,7 +81,6 @@ func (v *vimstate) handleProtocolLocations(b *types.Buffer, pos types.CursorPosi
 		},
 	})
 	v.jumpStackPos++
-	return &loc, nil
 }
 
 func (v *vimstate) gotoPrevDef(flags govim.CommandFlags, args ...string) error {
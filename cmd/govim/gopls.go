@@ -9,64 +9,29 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/govim/govim"
 	"github.com/govim/govim/cmd/govim/config"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/fakenet"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/jsonrpc2"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/span"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
 	"github.com/govim/govim/cmd/govim/internal/util"
 )
 
 func (g *govimplugin) startGopls() error {
-	goplsArgs := []string{"-rpc.trace"}
-
-	if g.logging["on"] {
-		logfile, err := g.createLogFile("gopls")
-		if err != nil {
-			return err
-		}
-		logfile.Close()
-		g.Logf("gopls log file: %v", logfile.Name())
-
-		g.ChannelExf("let s:gopls_logfile=%q", logfile.Name())
-		goplsArgs = append(goplsArgs, "-logfile", logfile.Name())
-	} else {
-		goplsArgs = append(goplsArgs, "-logfile", os.DevNull)
+	gopls, logfile, err := g.goplsCommand("gopls")
+	if err != nil {
+		return err
 	}
-
-	if flags, err := util.Split(os.Getenv(string(config.EnvVarGoplsFlags))); err != nil {
-		g.Logf("invalid env var %s: %v", config.EnvVarGoplsFlags, err)
-	} else {
-		goplsArgs = append(goplsArgs, flags...)
-	}
-
-	gopls := exec.Command(g.goplspath, goplsArgs...)
-	gopls.Env = g.goplsEnv
-	if ev, ok := os.LookupEnv(string(config.EnvVarGoplsGOMAXPROCSMinusN)); ok {
-		v := strings.TrimSpace(ev)
-		var gmp int
-		if strings.HasSuffix(v, "%") {
-			v = strings.TrimSuffix(v, "%")
-			p, err := strconv.ParseFloat(v, 64)
-			if err != nil {
-				return fmt.Errorf("failed to parse percentage from %v value %q: %v", config.EnvVarGoplsGOMAXPROCSMinusN, ev, err)
-			}
-			gmp = int(math.Floor(float64(runtime.NumCPU()) * (1 - p/100)))
-		} else {
-			n, err := strconv.Atoi(v)
-			if err != nil {
-				return fmt.Errorf("failed to parse integer from %v value %q: %v", config.EnvVarGoplsGOMAXPROCSMinusN, ev, err)
-			}
-			gmp = runtime.NumCPU() - n
-		}
-		if gmp < 0 || gmp > runtime.NumCPU() {
-			return fmt.Errorf("%v value %q results in GOMAXPROCS value %v which is invalid", config.EnvVarGoplsGOMAXPROCSMinusN, ev, gmp)
-		}
-		g.Logf("Starting gopls with GOMAXPROCS=%v", gmp)
-		gopls.Env = append(gopls.Env, "GOMAXPROCS="+strconv.Itoa(gmp))
+	if logfile != "" {
+		g.Logf("gopls log file: %v", logfile)
+		g.ChannelExf("let s:gopls_logfile=%q", logfile)
 	}
 	g.Logf("Running gopls: %v", strings.Join(gopls.Args, " "))
 	stderr, err := gopls.StderrPipe()
@@ -92,22 +57,33 @@ func (g *govimplugin) startGopls() error {
 		return fmt.Errorf("failed to create stdin pipe for gopls: %v", err)
 	}
 	g.goplsStdin = stdin
+	g.goplsStarted = time.Now()
 	if err := gopls.Start(); err != nil {
 		return fmt.Errorf("failed to start gopls: %v", err)
 	}
-	g.tomb.Go(func() (err error) {
-		if err = gopls.Wait(); err != nil {
-			err = fmt.Errorf("got error running gopls: %v", err)
+	stopped := make(chan struct{})
+	exited := make(chan struct{})
+	g.goplsStopped = stopped
+	g.goplsExited = exited
+	g.tomb.Go(func() error {
+		err := gopls.Wait()
+		close(exited)
+		if err == nil {
+			err = fmt.Errorf("gopls exited")
 		}
 		select {
 		case <-g.inShutdown:
 			return nil
+		case <-stopped:
+			return nil
 		default:
-			if err != nil {
-				g.errCh <- err
-			}
-			return
 		}
+		g.Logf("got error running gopls: %v", err)
+		g.Schedule(func(govim.Govim) error {
+			g.vimstate.goplsCrashed(stopped, err)
+			return nil
+		})
+		return nil
 	})
 
 	fakeconn := fakenet.NewConn("stdio", stdout, stdin)
@@ -116,22 +92,36 @@ func (g *govimplugin) startGopls() error {
 	conn := jsonrpc2.NewConn(stream)
 	server := protocol.ServerDispatcher(conn)
 	handler := protocol.ClientHandler(g, jsonrpc2.MethodNotFound)
+	handler = g.applyEditHandler(handler)
 	handler = protocol.Handlers(handler)
 	ctxt = protocol.WithClient(ctxt, g)
 
 	g.tomb.Go(func() error {
 		conn.Go(ctxt, handler)
 		<-conn.Done()
-		return conn.Err()
+		select {
+		case <-g.inShutdown:
+			return nil
+		case <-stopped:
+			return nil
+		default:
+		}
+		// gopls is of no use without the connection, so make sure it exits
+		// such that it is restarted.
+		g.Logf("connection to gopls closed: %v", conn.Err())
+		gopls.Process.Kill()
+		return nil
 	})
 
 	g.gopls = gopls.Process
 	g.goplsConn = conn
 	g.goplsCancel = cancel
+	g.serverLock.Lock()
 	g.server = loggingGoplsServer{
 		u: server,
 		g: g,
 	}
+	g.serverLock.Unlock()
 
 	// gomodspec points at the workspace file (go.work) in workspace mode, or go.mod in
 	// module mode.
@@ -140,31 +130,48 @@ func (g *govimplugin) startGopls() error {
 		return fmt.Errorf("failed to derive go.work/go.mod path: %v", err)
 	}
 
-	// "go env GOMOD" might return an empty string (not module mode) or os.DevNull (in module
-	// mode but without a module root).
-	if gomodspec != "" && gomodspec != os.DevNull {
-		// i.e. we are in a module or a workspace
-		mw, err := newModWatcher(g, gomodspec)
-		if err != nil {
-			return fmt.Errorf("failed to create modWatcher for %v: %v", gomodspec, err)
-		}
-		g.modWatcher = mw
-	}
-
 	initParams := &protocol.ParamInitialize{}
-	initParams.WorkspaceFolders = []protocol.WorkspaceFolder{
-		{URI: string(span.URIFromPath(filepath.Dir(gomodspec)))},
-	}
+	g.workspaceFoldersLock.Lock()
+	g.workspaceFolders = []string{filepath.Dir(gomodspec)}
+	g.workspaceFoldersLock.Unlock()
+	// Watch the workspace folders until gopls registers the file system
+	// watchers it requires
+	g.vimstate.updateDirWatchers()
+	initParams.WorkspaceFolders = g.workspaceFolderList()
 	initParams.Capabilities.TextDocument.Hover = protocol.HoverClientCapabilities{
 		ContentFormat: []protocol.MarkupKind{protocol.PlainText},
 	}
 	initParams.Capabilities.Workspace.Configuration = true
-	// TODO: actually handle these registrations dynamically, if we ever want to
+	initParams.Capabilities.Workspace.WorkspaceFolders = true
+	// TODO: actually handle this registration dynamically, if we ever want to
 	// target language servers other than gopls.
 	initParams.Capabilities.Workspace.DidChangeConfiguration.DynamicRegistration = true
 	initParams.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration = true
+	initParams.Capabilities.Workspace.WorkspaceEdit = &protocol.WorkspaceEditClientCapabilities{
+		DocumentChanges:    true,
+		ResourceOperations: resourceOperationKinds,
+	}
+	initParams.Capabilities.Workspace.FileOperations = protocol.FileOperationClientCapabilities{
+		WillRename: true,
+		DidRename:  true,
+	}
 
 	initParams.Capabilities.Window.WorkDoneProgress = true
+	initParams.Capabilities.Window.ShowDocument.Support = true
+	initParams.Capabilities.TextDocument.FoldingRange.LineFoldingOnly = true
+	initParams.Capabilities.TextDocument.CodeAction.DataSupport = true
+	initParams.Capabilities.TextDocument.Completion.CompletionItem.TagSupport.ValueSet = []protocol.CompletionItemTag{protocol.ComplDeprecated}
+	initParams.Capabilities.TextDocument.CodeAction.ResolveSupport.Properties = []string{"edit"}
+	initParams.Capabilities.TextDocument.SemanticTokens = protocol.SemanticTokensClientCapabilities{
+		DynamicRegistration: true,
+		TokenTypes:          semanticTokenTypes,
+		TokenModifiers:      semanticTokenModifiers,
+		Formats:             []string{"relative"},
+		Requests: protocol.PRequestsPSemanticTokens{
+			Range: true,
+			Full:  protocol.FFullPRequests{Delta: true},
+		},
+	}
 
 	// Session-level config should be able to be set post initialize, but that
 	// is not currently supported by gopls. So for now a restart is required
@@ -174,7 +181,6 @@ func (g *govimplugin) startGopls() error {
 	// "thread" and hence whether this lock is required
 	g.vimstate.configLock.Lock()
 	conf := g.vimstate.config
-	defer g.vimstate.configLock.Unlock()
 	goplsConfig := make(map[string]interface{})
 	if conf.SymbolMatcher != nil {
 		goplsConfig[goplsSymbolMatcher] = *conf.SymbolMatcher
@@ -183,6 +189,11 @@ func (g *govimplugin) startGopls() error {
 		goplsConfig[goplsSymbolStyle] = *conf.SymbolStyle
 	}
 
+	// Client capabilities are only considered when gopls is initialised
+	if conf.CompletionSnippets != nil && *conf.CompletionSnippets {
+		initParams.Capabilities.TextDocument.Completion.CompletionItem.SnippetSupport = true
+	}
+
 	// This option was introduced as a way to opt-out from the changes introduced in CL 268597.
 	// According to CL 274532 (that added this opt-out), it is intended to be removed - "Ideally
 	// we'll be able to remove them in a few months after things stabilize.". We need to handle that
@@ -191,15 +202,282 @@ func (g *govimplugin) startGopls() error {
 		goplsConfig["allowModfileModifications"] = *conf.ExperimentalAllowModfileModifications
 	}
 
+	// gopls (un)registers support for semantic tokens as this option changes,
+	// but only considers the initial value when it is initialised
+	if conf.HighlightSemanticTokens != nil {
+		goplsConfig[goplsSemanticTokens] = *conf.HighlightSemanticTokens
+	}
+
 	initParams.InitializationOptions = goplsConfig
 
-	if _, err := g.server.Initialize(context.Background(), initParams); err != nil {
+	var extraBuildConfigs []config.BuildConfig
+	if conf.ExtraBuildConfigs != nil {
+		extraBuildConfigs = *conf.ExtraBuildConfigs
+	}
+	g.vimstate.configLock.Unlock()
+
+	initRes, err := g.server.Initialize(context.Background(), initParams)
+	if err != nil {
 		return fmt.Errorf("failed to initialise gopls: %v", err)
 	}
+	g.goplsCapabilities = initRes.Capabilities
 
 	if err := g.server.Initialized(context.Background(), &protocol.InitializedParams{}); err != nil {
 		return fmt.Errorf("failed to call gopls.Initialized: %v", err)
 	}
 
+	if len(extraBuildConfigs) > 0 {
+		if err := g.startBuildConfigGopls(extraBuildConfigs); err != nil {
+			return err
+		}
+		g.serverLock.Lock()
+		g.server = buildConfigsServer{
+			Server: g.server,
+			g:      g,
+		}
+		g.serverLock.Unlock()
+	}
+
 	return nil
 }
+
+// goplsServer returns the current gopls instance, for use other than on the
+// vim thread.
+func (g *govimplugin) goplsServer() protocol.Server {
+	g.serverLock.Lock()
+	defer g.serverLock.Unlock()
+	return g.server
+}
+
+// goplsCommand returns the command to run a gopls instance which, if logging
+// is enabled, logs to a new log file with the prefix logPrefix. The name of
+// that log file, if any, is also returned.
+func (g *govimplugin) goplsCommand(logPrefix string) (*exec.Cmd, string, error) {
+	goplsArgs := []string{"-rpc.trace"}
+
+	var logfile string
+	if g.logging["on"] {
+		f, err := g.createLogFile(logPrefix)
+		if err != nil {
+			return nil, "", err
+		}
+		f.Close()
+		logfile = f.Name()
+		goplsArgs = append(goplsArgs, "-logfile", logfile)
+	} else {
+		goplsArgs = append(goplsArgs, "-logfile", os.DevNull)
+	}
+
+	g.vimstate.configLock.Lock()
+	var remote string
+	if g.vimstate.config.GoplsRemote != nil {
+		remote = goplsRemoteAddr(*g.vimstate.config.GoplsRemote)
+	}
+	g.vimstate.configLock.Unlock()
+	if remote != "" {
+		// gopls runs as a forwarder to the shared daemon. The -logfile above is
+		// that of the forwarder, and hence specific to this Vim instance.
+		goplsArgs = append(goplsArgs, "-remote="+remote)
+		if g.logging["on"] && strings.HasPrefix(remote, "auto") {
+			goplsArgs = append(goplsArgs, "-remote.logfile=auto")
+		}
+	}
+
+	if flags, err := util.Split(os.Getenv(string(config.EnvVarGoplsFlags))); err != nil {
+		g.Logf("invalid env var %s: %v", config.EnvVarGoplsFlags, err)
+	} else {
+		goplsArgs = append(goplsArgs, flags...)
+	}
+
+	gopls := exec.Command(g.goplspath, goplsArgs...)
+	gopls.Env = g.goplsEnv
+	if ev, ok := os.LookupEnv(string(config.EnvVarGoplsGOMAXPROCSMinusN)); ok {
+		v := strings.TrimSpace(ev)
+		var gmp int
+		if strings.HasSuffix(v, "%") {
+			v = strings.TrimSuffix(v, "%")
+			p, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to parse percentage from %v value %q: %v", config.EnvVarGoplsGOMAXPROCSMinusN, ev, err)
+			}
+			gmp = int(math.Floor(float64(runtime.NumCPU()) * (1 - p/100)))
+		} else {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to parse integer from %v value %q: %v", config.EnvVarGoplsGOMAXPROCSMinusN, ev, err)
+			}
+			gmp = runtime.NumCPU() - n
+		}
+		if gmp < 0 || gmp > runtime.NumCPU() {
+			return nil, "", fmt.Errorf("%v value %q results in GOMAXPROCS value %v which is invalid", config.EnvVarGoplsGOMAXPROCSMinusN, ev, gmp)
+		}
+		g.Logf("Starting gopls with GOMAXPROCS=%v", gmp)
+		gopls.Env = append(gopls.Env, "GOMAXPROCS="+strconv.Itoa(gmp))
+	}
+	return gopls, logfile, nil
+}
+
+// goplsRemoteAddr returns the value of the gopls -remote flag for the
+// config.Config.GoplsRemote value remote. A bare absolute path is taken to be
+// the path of a Unix socket.
+func goplsRemoteAddr(remote string) string {
+	remote = strings.TrimSpace(remote)
+	if filepath.IsAbs(remote) {
+		return "unix;" + remote
+	}
+	return remote
+}
+
+const (
+	// goplsRestartBackoff is how long we wait before restarting gopls after it
+	// first exits unexpectedly. The wait doubles with each subsequent restart.
+	goplsRestartBackoff = 500 * time.Millisecond
+
+	// maxGoplsRestarts is the number of times in a row we restart gopls after
+	// it exits unexpectedly, before we give up.
+	maxGoplsRestarts = 5
+
+	// goplsStableAfter is how long gopls needs to have been running for its
+	// exit to no longer count as one in a row.
+	goplsStableAfter = time.Minute
+)
+
+// goplsCrashed handles the unexpected exit, with error err, of the gopls
+// instance identified by stopped, restarting gopls with a backoff.
+func (v *vimstate) goplsCrashed(stopped chan struct{}, err error) {
+	if stopped != v.goplsStopped {
+		// gopls has already been restarted
+		return
+	}
+	if time.Since(v.goplsStarted) > goplsStableAfter {
+		v.goplsRestarts = 0
+	}
+	if v.goplsRestarts == maxGoplsRestarts {
+		err = fmt.Errorf("gopls exited unexpectedly %v times in a row: %v", maxGoplsRestarts+1, err)
+		go func() {
+			v.errCh <- err
+		}()
+		return
+	}
+	delay := goplsRestartBackoff << v.goplsRestarts
+	v.goplsRestarts++
+	v.showMessage("WarningMsg", fmt.Sprintf("gopls exited unexpectedly (%v); restarting it in %v", err, delay))
+	time.AfterFunc(delay, func() {
+		defer absorbShutdownErr()
+		select {
+		case <-v.inShutdown:
+			return
+		default:
+		}
+		v.govimplugin.Schedule(func(govim.Govim) error {
+			if stopped != v.goplsStopped {
+				return nil
+			}
+			if err := v.replaceGopls(); err != nil {
+				v.Logf("failed to restart gopls: %v", err)
+				v.goplsCrashed(v.goplsStopped, err)
+			}
+			return nil
+		})
+	})
+}
+
+// restartGopls restarts gopls at the request of the user, e.g. such that
+// changes to session-level config take effect.
+func (v *vimstate) restartGopls(flags govim.CommandFlags, args ...string) error {
+	v.goplsRestarts = 0
+	return v.replaceGopls()
+}
+
+// replaceGopls stops gopls and starts a new instance, which is told about
+// the buffers we have loaded.
+func (v *vimstate) replaceGopls() error {
+	v.stopGopls()
+
+	// Forget the state that came from the previous instance, cancelling any
+	// requests still in flight to it
+	v.watchedFiles = nil
+	v.pendingFileEvents = nil
+	v.semanticTokensLegend = nil
+	v.removeSemanticTokens()
+	v.removeInlayHints()
+	v.removeFolds()
+	v.cancelAsyncCompletion()
+	v.cancelSignatureHelp()
+	v.cancelCompletionDocs()
+	v.cancelDocHighlightLock.Lock()
+	if v.cancelDocHighlight != nil {
+		v.cancelDocHighlight()
+		v.cancelDocHighlight = nil
+	}
+	v.cancelDocHighlightLock.Unlock()
+	v.diagnosticsChangedLock.Lock()
+	v.rawDiagnostics = make(map[span.URI]*protocol.PublishDiagnosticsParams)
+	v.diagnosticsChanged = true
+	v.diagnosticsChangedLock.Unlock()
+	if err := v.handleDiagnosticsChanged(); err != nil {
+		return fmt.Errorf("failed to clear diagnostics: %v", err)
+	}
+
+	if err := v.startGopls(); err != nil {
+		return err
+	}
+
+	// Sort by buffer number so that we have reproducible behaviour
+	var bufs []*types.Buffer
+	for _, b := range v.buffers {
+		if b.Loaded {
+			bufs = append(bufs, b)
+		}
+	}
+	sort.Slice(bufs, func(i, j int) bool {
+		return bufs[i].Num < bufs[j].Num
+	})
+	for _, b := range bufs {
+		v.addWorkspaceFolderFor(b)
+		params := &protocol.DidOpenTextDocumentParams{
+			TextDocument: protocol.TextDocumentItem{
+				LanguageID: detectLanguage(b.URI().Filename()).String(),
+				URI:        protocol.DocumentURI(b.URI()),
+				Version:    b.Version,
+				Text:       string(b.Contents()),
+			},
+		}
+		if err := v.server.DidOpen(context.Background(), params); err != nil {
+			return fmt.Errorf("failed to call gopls.DidOpen on %v: %v", b.Name, err)
+		}
+		v.updateFolds(b)
+	}
+	return v.updateInlayHints()
+}
+
+// stopGopls stops the running gopls instance, if it has not already exited.
+func (g *govimplugin) stopGopls() {
+	select {
+	case <-g.goplsStopped:
+		// We failed to start gopls last time around
+		return
+	default:
+	}
+	close(g.goplsStopped)
+	g.stopBuildConfigGopls()
+	select {
+	case <-g.goplsExited:
+	default:
+		ctxt, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		if err := g.server.Shutdown(ctxt); err != nil {
+			g.Logf("failed to call gopls Shutdown: %v", err)
+		}
+		cancel()
+	}
+	if err := g.goplsStdin.Close(); err != nil {
+		g.Logf("failed to close gopls stdin: %v", err)
+	}
+	select {
+	case <-g.goplsExited:
+	case <-time.After(time.Second):
+		g.Logf("gopls did not exit; killing it")
+		g.gopls.Kill()
+	}
+	g.goplsCancel()
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"reflect"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/govim/govim"
 	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/glob"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/jsonrpc2"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/span"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/command"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
@@ -22,14 +26,18 @@ const (
 	goplsConfigHoverKind             = "hoverKind"
 	goplsDeepCompletion              = "deepCompletion"
 	goplsCompletionMatcher           = "matcher"
+	goplsUsePlaceholders             = "usePlaceholders"
 	goplsStaticcheck                 = "staticcheck"
 	goplsCompleteUnimported          = "completeUnimported"
+	goplsCompletionDocumentation     = "completionDocumentation"
 	goplsGoImportsLocalPrefix        = "local"
 	goplsCompletionBudget            = "completionBudget"
 	goplsTempModfile                 = "tempModfile"
 	goplsVerboseOutput               = "verboseOutput"
 	goplsEnv                         = "env"
 	goplsAnalyses                    = "analyses"
+	goplsHints                       = "hints"
+	goplsSemanticTokens              = "semanticTokens"
 	goplsCodeLenses                  = "codelenses"
 	goplsSymbolMatcher               = "symbolMatcher"
 	goplsSymbolStyle                 = "symbolStyle"
@@ -41,9 +49,29 @@ const (
 
 var _ protocol.Client = (*govimplugin)(nil)
 
-func (g *govimplugin) ShowDocument(context.Context, *protocol.ShowDocumentParams) (*protocol.ShowDocumentResult, error) {
+func (g *govimplugin) ShowDocument(ctxt context.Context, params *protocol.ShowDocumentParams) (*protocol.ShowDocumentResult, error) {
 	defer absorbShutdownErr()
-	panic("ShowDocument not implemented yet")
+	g.logGoplsClientf("ShowDocument callback: %v", pretty.Sprint(params))
+
+	// We don't wait for the document to be shown because gopls can ask for it
+	// whilst handling a call (e.g. ExecuteCommand) that blocks the vim thread.
+	// So we can only report failure for a file that doesn't exist; success
+	// means that the document will be shown, and the user is told if that
+	// then fails.
+	if !params.External && strings.HasPrefix(string(params.URI), "file://") {
+		fn := span.URI(params.URI).Filename()
+		if _, err := os.Stat(fn); err != nil {
+			g.logGoplsClientf("ShowDocument response: cannot show %v: %v", fn, err)
+			return &protocol.ShowDocumentResult{Success: false}, nil
+		}
+	}
+	g.Schedule(func(govim.Govim) error {
+		if err := g.vimstate.showDocument(params); err != nil {
+			g.vimstate.showMessage("WarningMsg", fmt.Sprintf("Failed to show document %v: %v", params.URI, err))
+		}
+		return nil
+	})
+	return &protocol.ShowDocumentResult{Success: true}, nil
 }
 
 func (g *govimplugin) ShowMessage(ctxt context.Context, params *protocol.ShowMessageParams) error {
@@ -61,25 +89,60 @@ func (g *govimplugin) ShowMessage(ctxt context.Context, params *protocol.ShowMes
 	}
 
 	g.Schedule(func(govim.Govim) error {
-		opts := make(map[string]interface{})
-		opts["mousemoved"] = "any"
-		opts["moved"] = "any"
-		opts["padding"] = []int{0, 1, 0, 1}
-		opts["wrap"] = true
-		opts["border"] = []int{}
-		opts["highlight"] = hl
-		opts["line"] = 1
-		opts["close"] = "click"
-
-		g.ChannelCall("popup_create", strings.Split(params.Message, "\n"), opts)
+		g.vimstate.showMessage(hl, params.Message)
 		return nil
 	})
 	return nil
 }
 
-func (g *govimplugin) ShowMessageRequest(context.Context, *protocol.ShowMessageRequestParams) (*protocol.MessageActionItem, error) {
+func (g *govimplugin) ShowMessageRequest(ctxt context.Context, params *protocol.ShowMessageRequestParams) (*protocol.MessageActionItem, error) {
+	return g.showMessageRequestWithin(ctxt, params, messageRequestTimeout)
+}
+
+// messageRequestTimeout is how long we wait for the user to respond to a
+// ShowMessageRequest, after which the popup is closed as if dismissed.
+// Messages from gopls are handled in order, so whilst we wait no other
+// message (diagnostics, progress etc) is handled.
+const messageRequestTimeout = 30 * time.Second
+
+// showMessageRequestWithin is ShowMessageRequest, closing the popup if the
+// user has not responded within timeout.
+func (g *govimplugin) showMessageRequestWithin(ctxt context.Context, params *protocol.ShowMessageRequestParams, timeout time.Duration) (*protocol.MessageActionItem, error) {
 	defer absorbShutdownErr()
-	panic("ShowMessageRequest not implemented yet")
+	g.logGoplsClientf("ShowMessageRequest callback: %v", pretty.Sprint(params))
+
+	if len(params.Actions) == 0 {
+		// Nothing to choose from, so this is no different to ShowMessage
+		return nil, g.ShowMessage(ctxt, &protocol.ShowMessageParams{Type: params.Type, Message: params.Message})
+	}
+	ctxt, cancel := context.WithTimeout(ctxt, timeout)
+	defer cancel()
+	selected := make(chan int, 1)
+	popupID := make(chan int, 1)
+	g.Schedule(func(govim.Govim) error {
+		popupID <- g.vimstate.showMessageRequest(params, selected)
+		return nil
+	})
+	select {
+	case i := <-selected:
+		g.logGoplsClientf("ShowMessageRequest response: %v", i)
+		if i < 0 {
+			return nil, nil
+		}
+		return &params.Actions[i], nil
+	case <-ctxt.Done():
+		// Either gopls no longer needs an answer, or the user has not given
+		// one in time
+		g.logGoplsClientf("ShowMessageRequest cancelled: %v", ctxt.Err())
+		g.Schedule(func(govim.Govim) error {
+			g.vimstate.closeMessageRequest(<-popupID)
+			return nil
+		})
+		if ctxt.Err() == context.DeadlineExceeded {
+			return nil, nil
+		}
+		return nil, ctxt.Err()
+	}
 }
 
 func (g *govimplugin) LogMessage(ctxt context.Context, params *protocol.LogMessageParams) error {
@@ -100,11 +163,42 @@ func (g *govimplugin) RegisterCapability(ctxt context.Context, params *protocol.
 		case "workspace/didChangeConfiguration":
 			// For now ignore per github.com/govim/govim/issues/949
 		case "workspace/didChangeWorkspaceFolders":
-			// For now ignore per github.com/govim/govim/issues/172
+			// We always notify gopls of changes to the workspace folders (see
+			// addWorkspaceFolderFor), so there is nothing to do here.
 		case "workspace/didChangeWatchedFiles":
-			// For now ignore per github.com/govim/govim/issues/950
+			var opts protocol.DidChangeWatchedFilesRegistrationOptions
+			byts, err := json.Marshal(r.RegisterOptions)
+			if err != nil {
+				return fmt.Errorf("failed to encode watched files registration options: %v", err)
+			}
+			if err := json.Unmarshal(byts, &opts); err != nil {
+				return fmt.Errorf("failed to decode watched files registration options: %v", err)
+			}
+			var watchers []fileSystemWatcher
+			for _, w := range opts.Watchers {
+				pattern, err := glob.Parse(w.GlobPattern)
+				if err != nil {
+					return fmt.Errorf("failed to parse watched files registration: %v", err)
+				}
+				watchers = append(watchers, fileSystemWatcher{glob: pattern, kind: w.Kind})
+			}
+			id := r.ID
+			g.Schedule(func(govim.Govim) error {
+				return g.vimstate.registerWatchedFiles(id, watchers)
+			})
 		case "textDocument/semanticTokens":
-			// For now ignore per github.com/govim/govim/issues/1083
+			var opts protocol.SemanticTokensOptions
+			byts, err := json.Marshal(r.RegisterOptions)
+			if err != nil {
+				return fmt.Errorf("failed to encode semantic tokens registration options: %v", err)
+			}
+			if err := json.Unmarshal(byts, &opts); err != nil {
+				return fmt.Errorf("failed to decode semantic tokens registration options: %v", err)
+			}
+			g.Schedule(func(govim.Govim) error {
+				g.vimstate.semanticTokensLegend = &opts.Legend
+				return g.vimstate.updateSemanticTokens()
+			})
 		default:
 			panic(fmt.Errorf("RegisterCapability called with unknown method: %v", r.Method))
 		}
@@ -122,7 +216,17 @@ func (g *govimplugin) UnregisterCapability(ctxt context.Context, params *protoco
 		case "workspace/didChangeWorkspaceFolders":
 			// For now ignore per #172
 		case "workspace/didChangeWatchedFiles":
-		// 	// For now ignore per #950
+			id := u.ID
+			g.Schedule(func(govim.Govim) error {
+				g.vimstate.unregisterWatchedFiles(id)
+				return nil
+			})
+		case "textDocument/semanticTokens":
+			g.Schedule(func(govim.Govim) error {
+				g.vimstate.semanticTokensLegend = nil
+				g.vimstate.removeSemanticTokens()
+				return nil
+			})
 		default:
 			panic(fmt.Errorf("UnregisterCapability called with unknown method: %v", pretty.Sprint(params)))
 		}
@@ -133,7 +237,9 @@ func (g *govimplugin) UnregisterCapability(ctxt context.Context, params *protoco
 
 func (g *govimplugin) WorkspaceFolders(context.Context) ([]protocol.WorkspaceFolder, error) {
 	defer absorbShutdownErr()
-	panic("WorkspaceFolders not implemented yet")
+	res := g.workspaceFolderList()
+	g.logGoplsClientf("WorkspaceFolders response: %v", pretty.Sprint(res))
+	return res, nil
 }
 
 func (g *govimplugin) Configuration(ctxt context.Context, params *protocol.ParamConfiguration) ([]interface{}, error) {
@@ -141,10 +247,6 @@ func (g *govimplugin) Configuration(ctxt context.Context, params *protocol.Param
 
 	g.logGoplsClientf("Configuration: %v", pretty.Sprint(params))
 
-	g.vimstate.configLock.Lock()
-	conf := g.vimstate.config
-	defer g.vimstate.configLock.Unlock()
-
 	// gopls now sends params.Items for each of the configured
 	// workspaces. For now, we assume that the first item will be
 	// for the section "gopls" and only configure that. We will
@@ -153,6 +255,19 @@ func (g *govimplugin) Configuration(ctxt context.Context, params *protocol.Param
 		return nil, fmt.Errorf("govim gopls client: expected at least one item, with the first section \"gopls\"")
 	}
 	res := make([]interface{}, len(params.Items))
+	res[0] = g.goplsConfiguration()
+
+	g.logGoplsClientf("Configuration response: %v", pretty.Sprint(res))
+	return res, nil
+}
+
+// goplsConfiguration returns the "gopls" section of the workspace
+// configuration, derived from the current config.
+func (g *govimplugin) goplsConfiguration() map[string]interface{} {
+	g.vimstate.configLock.Lock()
+	conf := g.vimstate.config
+	defer g.vimstate.configLock.Unlock()
+
 	goplsConfig := make(map[string]interface{})
 	goplsConfig[goplsConfigHoverKind] = "FullDocumentation"
 	if conf.CompletionDeepCompletions != nil {
@@ -161,12 +276,19 @@ func (g *govimplugin) Configuration(ctxt context.Context, params *protocol.Param
 	if conf.CompletionMatcher != nil {
 		goplsConfig[goplsCompletionMatcher] = *conf.CompletionMatcher
 	}
+	if conf.CompletionSnippets != nil {
+		goplsConfig[goplsUsePlaceholders] = *conf.CompletionSnippets
+	}
 	if conf.Staticcheck != nil {
 		goplsConfig[goplsStaticcheck] = *conf.Staticcheck
 	}
 	if conf.CompleteUnimported != nil {
 		goplsConfig[goplsCompleteUnimported] = *conf.CompleteUnimported
 	}
+	if conf.CompletionLazyDocumentation != nil {
+		// The documentation of the selected item is fetched via hover instead
+		goplsConfig[goplsCompletionDocumentation] = !*conf.CompletionLazyDocumentation
+	}
 	if conf.GoImportsLocalPrefix != nil {
 		goplsConfig[goplsGoImportsLocalPrefix] = *conf.GoImportsLocalPrefix
 	}
@@ -191,6 +313,12 @@ func (g *govimplugin) Configuration(ctxt context.Context, params *protocol.Param
 	if conf.Analyses != nil {
 		goplsConfig[goplsAnalyses] = *conf.Analyses
 	}
+	if conf.InlayHints != nil {
+		goplsConfig[goplsHints] = *conf.InlayHints
+	}
+	if conf.HighlightSemanticTokens != nil {
+		goplsConfig[goplsSemanticTokens] = *conf.HighlightSemanticTokens
+	}
 	goplsConfig[goplsCodeLenses] = map[string]bool{
 		string(command.GCDetails): true, // gc_details
 	}
@@ -202,13 +330,34 @@ func (g *govimplugin) Configuration(ctxt context.Context, params *protocol.Param
 	if g.vimstate.config.GoplsDirectoryFilters != nil {
 		goplsConfig[goplsDirectoryFilters] = *conf.GoplsDirectoryFilters
 	}
-	res[0] = goplsConfig
+	return goplsConfig
+}
 
-	g.logGoplsClientf("Configuration response: %v", pretty.Sprint(res))
-	return res, nil
+// applyEditHandler handles workspace/applyEdit requests, decoding their
+// parameters via documentChange, and passes all other requests to handler.
+func (g *govimplugin) applyEditHandler(handler jsonrpc2.Handler) jsonrpc2.Handler {
+	return func(ctx context.Context, reply jsonrpc2.Replier, req jsonrpc2.Request) error {
+		if req.Method() != "workspace/applyEdit" {
+			return handler(ctx, reply, req)
+		}
+		var params applyWorkspaceEditParams
+		if err := json.Unmarshal(req.Params(), &params); err != nil {
+			return reply(ctx, nil, fmt.Errorf("%w: %s", jsonrpc2.ErrParse, err))
+		}
+		res, err := g.applyEdit(&params)
+		return reply(ctx, res, err)
+	}
 }
 
+// ApplyEdit is not called for edits sent by gopls, which are handled by
+// applyEditHandler, but is required to implement protocol.Client.
 func (g *govimplugin) ApplyEdit(ctxt context.Context, params *protocol.ApplyWorkspaceEditParams) (*protocol.ApplyWorkspaceEditResult, error) {
+	p := &applyWorkspaceEditParams{Label: params.Label}
+	p.Edit.DocumentChanges = documentChanges(params.Edit.DocumentChanges)
+	return g.applyEdit(p)
+}
+
+func (g *govimplugin) applyEdit(params *applyWorkspaceEditParams) (*protocol.ApplyWorkspaceEditResult, error) {
 	defer absorbShutdownErr()
 	g.logGoplsClientf("ApplyEdit: %v", pretty.Sprint(params))
 
@@ -302,6 +451,7 @@ func (g *govimplugin) Progress(ctxt context.Context, params *protocol.ProgressPa
 	if title, ok = raw["title"].(string); !ok && kind == "begin" { // required for "begin"
 		return fmt.Errorf("expected required field 'title' not set")
 	}
+	cancellable, _ := raw["cancellable"].(bool) // optional
 
 	g.Schedule(func(govim.Govim) error {
 		v := g.vimstate
@@ -310,6 +460,10 @@ func (g *govimplugin) Progress(ctxt context.Context, params *protocol.ProgressPa
 		if !ok {
 			return nil
 		}
+		if kind == "begin" {
+			popup.Title = title
+			popup.Cancellable = cancellable
+		}
 
 		return v.handleProgress(popup, kind, title, message)
 	})
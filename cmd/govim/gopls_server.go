@@ -526,7 +526,7 @@ func (l loggingGoplsServer) DidSaveNotebookDocument(ctxt context.Context, params
 }
 
 func (l loggingGoplsServer) InlayHint(ctxt context.Context, params *protocol.InlayHintParams) ([]protocol.InlayHint, error) {
-	l.Logf("gopls.InlayHint() call; params:\n", pretty.Sprint(params))
+	l.Logf("gopls.InlayHint() call; params:\n%v", pretty.Sprint(params))
 	res, err := l.u.InlayHint(ctxt, params)
 	l.Logf("gopls.InlayHint() return; err: %v; res:\n%v", err, pretty.Sprint(res))
 	return res, err
@@ -540,7 +540,7 @@ func (l loggingGoplsServer) InlayHintRefresh(ctxt context.Context) error {
 }
 
 func (l loggingGoplsServer) ResolveCompletionItem(ctxt context.Context, params *protocol.CompletionItem) (*protocol.CompletionItem, error) {
-	l.Logf("gopls.ResolveCompletionItem() call; params:\n", pretty.Sprint(params))
+	l.Logf("gopls.ResolveCompletionItem() call; params:\n%v", pretty.Sprint(params))
 	res, err := l.u.ResolveCompletionItem(ctxt, params)
 	l.Logf("gopls.ResolveCompletionItem() return; err: %v; res:\n%v", err, pretty.Sprint(res))
 	return res, err
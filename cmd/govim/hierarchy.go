@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+)
+
+// hierarchy is the govim representation of a tree rendered in a scratch
+// buffer, e.g. the callers of a function. Nodes are expanded lazily, i.e. the
+// children of a node are only requested from gopls when the node is first
+// expanded.
+type hierarchy struct {
+	bufNr int
+	roots []*hierarchyNode
+
+	// lines is the node rendered on each line of the buffer, i.e. the node
+	// for Vim line n is lines[n-1]
+	lines []*hierarchyNode
+}
+
+type hierarchyNode struct {
+	name string
+
+	// loc is the location to jump to when the node is selected. Nodes that
+	// only group other nodes have no location, in which case selecting the
+	// node toggles it.
+	loc protocol.Location
+
+	expanded bool
+
+	// loaded indicates whether children has been populated via expand
+	loaded   bool
+	children []*hierarchyNode
+
+	// expand returns the children of the node
+	expand func() ([]*hierarchyNode, error)
+}
+
+// toggle expands n if it is collapsed, loading its children if required, or
+// collapses n if it is expanded.
+func (n *hierarchyNode) toggle() error {
+	if n.expanded {
+		n.expanded = false
+		return nil
+	}
+	if !n.loaded {
+		children, err := n.expand()
+		if err != nil {
+			return err
+		}
+		sortHierarchyNodes(children)
+		n.children = children
+		n.loaded = true
+	}
+	n.expanded = true
+	return nil
+}
+
+// sortHierarchyNodes sorts nodes by location so that we have reproducible
+// behaviour.
+func sortHierarchyNodes(nodes []*hierarchyNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		lhs, rhs := nodes[i].loc, nodes[j].loc
+		if lhs.URI != rhs.URI {
+			return lhs.URI < rhs.URI
+		}
+		if lhs.Range.Start.Line != rhs.Range.Start.Line {
+			return lhs.Range.Start.Line < rhs.Range.Start.Line
+		}
+		return lhs.Range.Start.Character < rhs.Range.Start.Character
+	})
+}
+
+// render flattens the visible nodes of h into lines of text, one per node,
+// indented according to depth. File names are made relative to wd for
+// reporting purposes.
+func (h *hierarchy) render(wd string) []string {
+	h.lines = nil
+	var res []string
+	var walk func(nodes []*hierarchyNode, depth int)
+	walk = func(nodes []*hierarchyNode, depth int) {
+		for _, n := range nodes {
+			marker := "+"
+			switch {
+			case n.loaded && len(n.children) == 0:
+				marker = " "
+			case n.expanded:
+				marker = "-"
+			}
+			line := fmt.Sprintf("%s%s %s", strings.Repeat("  ", depth), marker, n.name)
+			if n.loc.URI != "" {
+				fn := n.loc.URI.SpanURI().Filename()
+				if rel, err := filepath.Rel(wd, fn); err == nil {
+					fn = rel
+				}
+				line += fmt.Sprintf("  %s:%d", fn, n.loc.Range.Start.Line+1)
+			}
+			res = append(res, line)
+			h.lines = append(h.lines, n)
+			if n.expanded {
+				walk(n.children, depth+1)
+			}
+		}
+	}
+	walk(h.roots, 0)
+	return res
+}
+
+// openHierarchy renders roots in the scratch buffer name, creating the buffer
+// if required, and moves the cursor to a window showing it. mods are the
+// command modifiers used when a new window needs to be split.
+func (v *vimstate) openHierarchy(mods govim.CommModList, name string, roots []*hierarchyNode) error {
+	for _, r := range roots {
+		if err := r.toggle(); err != nil {
+			return err
+		}
+	}
+	v.showHierarchy(mods, name, roots)
+	return nil
+}
+
+// showHierarchy renders roots, as is, in the scratch buffer name. See
+// openHierarchy.
+func (v *vimstate) showHierarchy(mods govim.CommModList, name string, roots []*hierarchyNode) *hierarchy {
+	bufNr := v.ParseInt(v.ChannelCall("bufadd", name))
+	v.ChannelExf("silent call bufload(%d)", bufNr)
+	v.BatchStart()
+	v.BatchChannelCall("setbufvar", bufNr, "&buftype", "nofile")
+	v.BatchChannelCall("setbufvar", bufNr, "&bufhidden", "hide")
+	v.BatchChannelCall("setbufvar", bufNr, "&swapfile", 0)
+	v.BatchChannelCall("setbufvar", bufNr, "&buflisted", 0)
+	v.MustBatchEnd()
+
+	h := &hierarchy{
+		bufNr: bufNr,
+		roots: roots,
+	}
+	v.hierarchies[bufNr] = h
+	v.redrawHierarchy(h)
+
+	var wins []int
+	v.Parse(v.ChannelCall("win_findbuf", bufNr), &wins)
+	if len(wins) > 0 {
+		v.ChannelCall("win_gotoid", wins[0])
+	} else {
+		v.ChannelExf("%v sbuffer %d", mods, bufNr)
+	}
+	v.ChannelExf(`nnoremap <buffer> <silent> <Tab> :call %v%v(bufnr(""), line("."))<CR>`, v.Prefix(), config.FunctionHierarchyToggle)
+	v.ChannelExf(`nnoremap <buffer> <silent> <CR> :call %v%v(bufnr(""), line("."))<CR>`, v.Prefix(), config.FunctionHierarchyJump)
+	v.ChannelCall("cursor", 1, 1)
+	return h
+}
+
+// redrawHierarchy replaces the contents of the buffer behind h with the
+// currently visible nodes.
+func (v *vimstate) redrawHierarchy(h *hierarchy) {
+	lines := h.render(v.workingDirectory)
+	v.BatchStart()
+	v.BatchChannelCall("setbufvar", h.bufNr, "&modifiable", 1)
+	v.BatchChannelCall("setbufline", h.bufNr, 1, lines)
+	v.BatchChannelCall("deletebufline", h.bufNr, len(lines)+1, "$")
+	v.BatchChannelCall("setbufvar", h.bufNr, "&modifiable", 0)
+	v.MustBatchEnd()
+}
+
+// hierarchyNodeAt returns the node rendered on line in the hierarchy buffer
+// bufnr. args are expected to be the bufnr and line arguments passed to the
+// internal hierarchy functions.
+func (v *vimstate) hierarchyNodeAt(args ...json.RawMessage) (*hierarchy, *hierarchyNode, error) {
+	bufNr := v.ParseInt(args[0])
+	line := v.ParseInt(args[1])
+	h, ok := v.hierarchies[bufNr]
+	if !ok {
+		return nil, nil, fmt.Errorf("buffer %v is not a hierarchy buffer", bufNr)
+	}
+	if line < 1 || line > len(h.lines) {
+		return nil, nil, fmt.Errorf("line %v is not a node in hierarchy buffer %v", line, bufNr)
+	}
+	return h, h.lines[line-1], nil
+}
+
+func (v *vimstate) hierarchyToggle(args ...json.RawMessage) (interface{}, error) {
+	h, n, err := v.hierarchyNodeAt(args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.toggle(); err != nil {
+		return nil, err
+	}
+	v.redrawHierarchy(h)
+	return nil, nil
+}
+
+func (v *vimstate) hierarchyJump(args ...json.RawMessage) (interface{}, error) {
+	_, n, err := v.hierarchyNodeAt(args...)
+	if err != nil {
+		return nil, err
+	}
+	if n.loc.URI == "" {
+		return v.hierarchyToggle(args...)
+	}
+	// Like the quickfix window, jump from the window that was previously
+	// active rather than replacing the hierarchy buffer
+	v.ChannelEx("wincmd p")
+	if p, err := v.cursorPos(); err == nil && p.Point != nil {
+		v.pushJumpStack(p.Buffer(), p)
+	}
+	return nil, v.loadLocation(nil, n.loc)
+}
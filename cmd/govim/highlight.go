@@ -28,6 +28,14 @@ type propAddDict struct {
 	BufNr   int    `json:"bufnr"`
 }
 
+// propAddTextDict is the representation of arguments used in vim's prop_add()
+// to add virtual text
+type propAddTextDict struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	BufNr int    `json:"bufnr"`
+}
+
 // assertPropAdd is used when we add text properties that might fail due to the fact
 // that the buffer might have changed since the text properties was calculated.
 // There are two vim errors that we like to suppress, invalid line and invalid column.
@@ -79,6 +87,54 @@ func (v *vimstate) textpropDefine() error {
 		Priority:  types.SeverityPriority[types.SeverityErr] + 1,
 	})
 
+	v.BatchChannelCall("prop_type_add", config.HighlightInlayHint, propDict{
+		Highlight: string(config.HighlightInlayHint),
+	})
+
+	v.BatchChannelCall("prop_type_add", config.HighlightVulncheck, propDict{
+		Highlight: string(config.HighlightVulncheck),
+	})
+
+	for _, hi := range []config.Highlight{config.HighlightCovered, config.HighlightUncovered} {
+		v.BatchChannelCall("prop_type_add", hi, propDict{
+			Highlight: string(hi),
+			Combine:   true, // Combine with syntax highlight
+		})
+	}
+	v.BatchChannelCall("prop_type_add", config.HighlightCoverageSummary, propDict{
+		Highlight: string(config.HighlightCoverageSummary),
+	})
+
+	// Text typed at either end of a placeholder becomes part of it
+	v.BatchChannelCall("prop_type_add", config.HighlightSnippetPlaceholder, propDict{
+		Highlight: string(config.HighlightSnippetPlaceholder),
+		Combine:   true,
+		StartIncl: true,
+		EndIncl:   true,
+	})
+
+	v.BatchChannelCall("prop_type_add", config.HighlightCompletionDeprecated, propDict{
+		Highlight: string(config.HighlightCompletionDeprecated),
+		Combine:   true,
+	})
+
+	for _, hi := range []config.Highlight{
+		config.HighlightSemanticNamespace,
+		config.HighlightSemanticType,
+		config.HighlightSemanticInterface,
+		config.HighlightSemanticTypeParameter,
+		config.HighlightSemanticParameter,
+		config.HighlightSemanticVariable,
+		config.HighlightSemanticReadonlyVariable,
+		config.HighlightSemanticFunction,
+		config.HighlightSemanticMethod,
+	} {
+		v.BatchChannelCall("prop_type_add", hi, propDict{
+			Highlight: string(hi),
+			Combine:   true, // Combine with syntax highlight
+		})
+	}
+
 	res := v.MustBatchEnd()
 	for i := range res {
 		if v.ParseInt(res[i]) != 0 {
@@ -204,7 +260,7 @@ func (v *vimstate) updateReferenceHighlightAtCursorPosition(force bool, cursorPo
 }
 
 func (g *govimplugin) redefineReferenceHighlight(ctx context.Context, cursorPos types.CursorPosition) {
-	res, err := g.server.DocumentHighlight(ctx,
+	res, err := g.goplsServer().DocumentHighlight(ctx,
 		&protocol.DocumentHighlightParams{
 			TextDocumentPositionParams: protocol.TextDocumentPositionParams{
 				TextDocument: protocol.TextDocumentIdentifier{
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+// inlayHintsEnabled returns true if at least one kind of inlay hint is enabled
+// and Vim supports showing them.
+func (v *vimstate) inlayHintsEnabled() bool {
+	if !v.hasVirtualText || v.config.InlayHints == nil {
+		return false
+	}
+	for _, enabled := range *v.config.InlayHints {
+		if enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// updateInlayHints requests inlay hints for the visible lines of buffers,
+// replacing any hints currently shown in those buffers once gopls responds.
+func (v *vimstate) updateInlayHints() error {
+	if !v.inlayHintsEnabled() {
+		return nil
+	}
+	visible := v.visibleBufferLines()
+	ctx, cancel := context.WithCancel(context.Background())
+	// Cancel any ongoing requests to make sure that we only process the
+	// latest response.
+	v.cancelInlayHintsLock.Lock()
+	if v.cancelInlayHints != nil {
+		v.cancelInlayHints()
+	}
+	v.cancelInlayHints = cancel
+	v.cancelInlayHintsLock.Unlock()
+
+	v.tomb.Go(func() error {
+		v.redefineInlayHints(ctx, visible)
+		return nil
+	})
+	return nil
+}
+
+// redefineInlayHints requests inlay hints for each of all. A buffer for
+// which the request fails is left as is, without affecting the hints of the
+// other buffers.
+func (g *govimplugin) redefineInlayHints(ctx context.Context, all []visibleLines) {
+	var visible []visibleLines
+	var hints [][]protocol.InlayHint
+	for _, l := range all {
+		res, err := g.goplsServer().InlayHint(ctx, &protocol.InlayHintParams{
+			TextDocument: l.buf.ToTextDocumentIdentifier(),
+			Range:        l.toRange(),
+		})
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if err != nil {
+			g.Logf("inlayHint call for buffer %v failed: %v", l.buf.Num, err)
+			continue
+		}
+		visible = append(visible, l)
+		hints = append(hints, res)
+	}
+
+	g.Schedule(func(govim.Govim) error {
+		// If the context is cancelled, a new InlayHint request has or will soon
+		// be sent and this one is no longer relevant so we just return here.
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		return g.vimstate.handleInlayHints(visible, hints)
+	})
+}
+
+func (v *vimstate) handleInlayHints(visible []visibleLines, hints [][]protocol.InlayHint) error {
+	v.BatchStart()
+	defer v.BatchCancelIfNotEnded()
+	for i, l := range visible {
+		b := l.buf
+		if !b.Loaded {
+			continue // vim removes properties when a buffer is unloaded
+		}
+		if b.Version != l.version {
+			// The buffer has changed since the hints were requested, which
+			// means the positions might no longer be valid. bufChanged will
+			// have triggered another request.
+			continue
+		}
+		v.removeBufferInlayHints(b)
+		for _, h := range hints[i] {
+			pos, err := types.PointFromPosition(b, *h.Position)
+			if err != nil {
+				v.Logf("failed to convert inlay hint position %v to point: %v", h.Position, err)
+				continue
+			}
+			v.BatchAssertChannelCall(assertPropAdd, "prop_add",
+				pos.Line(),
+				pos.Col(),
+				propAddTextDict{string(config.HighlightInlayHint), inlayHintText(h), b.Num},
+			)
+		}
+	}
+	v.MustBatchEnd()
+	return nil
+}
+
+// inlayHintText returns the virtual text used to render h.
+func inlayHintText(h protocol.InlayHint) string {
+	var sb strings.Builder
+	if h.PaddingLeft {
+		sb.WriteString(" ")
+	}
+	for _, p := range h.Label {
+		sb.WriteString(p.Value)
+	}
+	if h.PaddingRight {
+		sb.WriteString(" ")
+	}
+	return sb.String()
+}
+
+// removeBufferInlayHints removes all inlay hints from b.
+func (v *vimstate) removeBufferInlayHints(b *types.Buffer) {
+	var didStart bool
+	if didStart = v.BatchStartIfNeeded(); didStart {
+		defer v.BatchCancelIfNotEnded()
+	}
+	v.BatchChannelCall("prop_remove", struct {
+		Type  string `json:"type"`
+		BufNr int    `json:"bufnr"`
+		All   int    `json:"all"`
+	}{string(config.HighlightInlayHint), b.Num, 1})
+	if didStart {
+		v.MustBatchEnd()
+	}
+}
+
+// removeInlayHints removes the inlay hints from all buffers, and cancels any
+// ongoing requests for hints.
+func (v *vimstate) removeInlayHints() {
+	v.cancelInlayHintsLock.Lock()
+	if v.cancelInlayHints != nil {
+		v.cancelInlayHints()
+		v.cancelInlayHints = nil
+	}
+	v.cancelInlayHintsLock.Unlock()
+
+	v.BatchStart()
+	defer v.BatchCancelIfNotEnded()
+	for _, b := range v.buffers {
+		if !b.Loaded {
+			continue // vim removes properties when a buffer is unloaded
+		}
+		v.removeBufferInlayHints(b)
+	}
+	v.MustBatchEnd()
+}
@@ -0,0 +1,171 @@
+// Package glob implements the glob patterns used by the Language Server
+// Protocol, for example in file system watcher registrations.
+//
+// Within a pattern, "*" matches zero or more characters in a path segment,
+// "?" matches one character in a path segment, "**" matches any number of path
+// segments (including none), "{a,b}" matches either of the comma-separated
+// patterns, "[a-z]" matches a character in the range and "[!a-z]" matches a
+// character not in the range.
+package glob
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Glob is a parsed glob pattern.
+type Glob struct {
+	pattern string
+
+	// alts are the brace-free alternatives of pattern, each split into
+	// path segments.
+	alts [][]string
+}
+
+// Parse parses the glob pattern pattern, in which path segments are separated
+// by '/'.
+func Parse(pattern string) (*Glob, error) {
+	alts, err := expand(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %v", pattern, err)
+	}
+	res := &Glob{pattern: pattern}
+	for _, alt := range alts {
+		segs := strings.Split(alt, "/")
+		for i, seg := range segs {
+			if seg == "**" {
+				continue
+			}
+			seg = strings.ReplaceAll(seg, "**", "*")
+			seg = strings.ReplaceAll(seg, "[!", "[^")
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob pattern %q: %v", pattern, err)
+			}
+			segs[i] = seg
+		}
+		res.alts = append(res.alts, segs)
+	}
+	return res, nil
+}
+
+// String returns the pattern from which g was parsed.
+func (g *Glob) String() string {
+	return g.pattern
+}
+
+// IsAbs reports whether g only matches absolute paths.
+func (g *Glob) IsAbs() bool {
+	for _, segs := range g.alts {
+		if segs[0] != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Root returns the longest directory that contains every path matched by g,
+// or "" if g is relative or the only such directory is "/".
+func (g *Glob) Root() string {
+	if !g.IsAbs() || strings.HasPrefix(g.pattern, "{") {
+		return ""
+	}
+	segs := strings.Split(g.pattern, "/")
+	n := 0
+	for n < len(segs) && !strings.ContainsAny(segs[n], "*?[{") {
+		n++
+	}
+	if n == len(segs) {
+		// The pattern is a literal path, which is contained by its directory
+		n--
+	}
+	return strings.Join(segs[:n], "/")
+}
+
+// Match reports whether g matches name, a path in which segments are separated
+// by '/'.
+func (g *Glob) Match(name string) bool {
+	segs := strings.Split(name, "/")
+	for _, alt := range g.alts {
+		if match(alt, segs) {
+			return true
+		}
+	}
+	return false
+}
+
+func match(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for len(pat) > 1 && pat[1] == "**" {
+				pat = pat[1:]
+			}
+			for i := 0; i <= len(segs); i++ {
+				if match(pat[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// expand returns the alternatives described by the brace expressions of
+// pattern, which may be nested.
+func expand(pattern string) ([]string, error) {
+	start := -1
+	depth := 0
+	var alts []string
+	var last int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '[':
+			// Skip character classes, in which braces are not special
+			if j := strings.IndexByte(pattern[i+1:], ']'); j >= 0 {
+				i += j + 1
+			}
+		case '{':
+			if depth == 0 {
+				start = i
+				last = i + 1
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				alts = append(alts, pattern[last:i])
+				last = i + 1
+			}
+		case '}':
+			if depth == 0 {
+				return nil, fmt.Errorf("unexpected '}' at offset %v", i)
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			alts = append(alts, pattern[last:i])
+			prefix, suffix := pattern[:start], pattern[i+1:]
+			var res []string
+			for _, alt := range alts {
+				exp, err := expand(prefix + alt + suffix)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, exp...)
+			}
+			return res, nil
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("unterminated '{' at offset %v", start)
+	}
+	return []string{pattern}, nil
+}
@@ -0,0 +1,85 @@
+package glob_test
+
+import (
+	"testing"
+
+	"github.com/govim/govim/cmd/govim/internal/glob"
+)
+
+var matchTests = []struct {
+	pattern string
+	name    string
+	want    bool
+}{
+	{"**/*.go", "/a/b/c.go", true},
+	{"**/*.go", "c.go", true},
+	{"**/*.go", "/a/b/c.mod", false},
+	{"**/*.{go,mod,sum,work}", "/a/go.work", true},
+	{"**/*.{go,mod,sum,work}", "/a/go.sum.orig", false},
+	{"/a/**/*.go", "/a/c.go", true},
+	{"/a/**/*.go", "/a/b/c/d.go", true},
+	{"/a/**/*.go", "/b/c.go", false},
+	{"/a/*.go", "/a/b/c.go", false},
+	{"/a/go.work", "/a/go.work", true},
+	{"/a/go.work", "/a/b/go.work", false},
+	{"{/a/b,/a/c}", "/a/c", true},
+	{"{/a/b,/a/c}", "/a/d", false},
+	{"/a/{b,c/{d,e}}/*.go", "/a/c/e/f.go", true},
+	{"/a/{b,c/{d,e}}/*.go", "/a/c/f.go", false},
+	{"/a/file?.go", "/a/file1.go", true},
+	{"/a/file?.go", "/a/file10.go", false},
+	{"/a/[a-c].go", "/a/b.go", true},
+	{"/a/[!a-c].go", "/a/b.go", false},
+	{"/a/[!a-c].go", "/a/d.go", true},
+	{"/a/[,{].go", "/a/{.go", true},
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range matchTests {
+		g, err := glob.Parse(tt.pattern)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.pattern, err)
+			continue
+		}
+		if got := g.Match(tt.name); got != tt.want {
+			t.Errorf("Parse(%q).Match(%q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+var rootTests = []struct {
+	pattern string
+	abs     bool
+	root    string
+}{
+	{"**/*.go", false, ""},
+	{"/a/b/**/*.go", true, "/a/b"},
+	{"/a/b/go.work", true, "/a/b"},
+	{"/a/b{c,d}/*.go", true, "/a"},
+	{"{/a/b,/a/c}", true, ""},
+	{"/*.go", true, ""},
+}
+
+func TestRoot(t *testing.T) {
+	for _, tt := range rootTests {
+		g, err := glob.Parse(tt.pattern)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.pattern, err)
+			continue
+		}
+		if got := g.IsAbs(); got != tt.abs {
+			t.Errorf("Parse(%q).IsAbs() = %v, want %v", tt.pattern, got, tt.abs)
+		}
+		if got := g.Root(); got != tt.root {
+			t.Errorf("Parse(%q).Root() = %q, want %q", tt.pattern, got, tt.root)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, pattern := range []string{"/a/{b,c", "/a/b}", "/a/[b"} {
+		if _, err := glob.Parse(pattern); err == nil {
+			t.Errorf("Parse(%q) succeeded; want error", pattern)
+		}
+	}
+}
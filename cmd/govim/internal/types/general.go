@@ -87,6 +87,14 @@ var SeverityHoverHighlight = map[Severity]config.Highlight{
 type TextPropID int
 
 const (
-	DiagnosticTextPropID = 0
-	ReferencesTextPropID = 1
+	DiagnosticTextPropID    = 0
+	ReferencesTextPropID    = 1
+	SemanticTokenTextPropID = 2
+	VulncheckTextPropID     = 3
+	CoverageTextPropID      = 4
+
+	// SnippetTextPropID is the ID of the first placeholder of a snippet, the
+	// placeholders using consecutive IDs such that they can be told apart.
+	// Hence it is kept well apart from the other IDs.
+	SnippetTextPropID = 1000
 )
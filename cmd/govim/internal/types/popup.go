@@ -33,10 +33,15 @@ const (
 // popups. Initiator is a optional field used to describe who initiated this
 // progress (if known), e.g. "GoTest" when running GOVIMGoTest. This allow
 // us to handle text from different commands to be handled differently (or
-// even suppressed).
+// even suppressed). Cancellable indicates whether the work can be cancelled
+// via a WorkDoneProgressCancel request, which only makes sense until the
+// progress has Ended.
 type ProgressPopup struct {
-	ID        int
-	Text      strings.Builder
-	LinePos   int
-	Initiator ProgressInitiator
+	ID          int
+	Text        strings.Builder
+	LinePos     int
+	Initiator   ProgressInitiator
+	Title       string
+	Cancellable bool
+	Ended       bool
 }
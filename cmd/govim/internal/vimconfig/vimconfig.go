@@ -12,9 +12,15 @@ type VimConfig struct {
 	QuickfixSigns                                *int
 	HighlightDiagnostics                         *int
 	HighlightReferences                          *int
+	HighlightSemanticTokens                      *int
 	HoverDiagnostics                             *int
 	CompletionDeepCompletions                    *int
 	CompletionMatcher                            *config.CompletionMatcher
+	CompletionSnippets                           *int
+	CompletionAsync                              *int
+	CompletionLazyDocumentation                  *int
+	CompletionKindIcons                          *map[string]string
+	SignatureHelpAuto                            *int
 	SymbolMatcher                                *config.SymbolMatcher
 	SymbolStyle                                  *config.SymbolStyle
 	Staticcheck                                  *int
@@ -24,8 +30,14 @@ type VimConfig struct {
 	TempModfile                                  *int
 	GoplsEnv                                     *map[string]string
 	GoplsDirectoryFilters                        *[]string
+	ExtraBuildConfigs                            *[]config.BuildConfig
 	Analyses                                     *map[string]int
+	InlayHints                                   *map[string]int
+	Folding                                      *int
 	OpenLastProgressWith                         *string
+	OpenExternalWith                             *string
+	GoplsRemote                                  *string
+	VulncheckDB                                  *string
 	Gofumpt                                      *int
 	ExperimentalAutoreadLoadedBuffers            *int
 	ExperimentalMouseTriggeredHoverPopupOptions  *map[string]interface{}
@@ -44,9 +56,15 @@ func (c *VimConfig) ToConfig(d config.Config) config.Config {
 		QuickfixAutoDiagnostics:           boolVal(c.QuickfixAutoDiagnostics, d.QuickfixAutoDiagnostics),
 		HighlightDiagnostics:              boolVal(c.HighlightDiagnostics, d.HighlightDiagnostics),
 		HighlightReferences:               boolVal(c.HighlightReferences, d.HighlightReferences),
+		HighlightSemanticTokens:           boolVal(c.HighlightSemanticTokens, d.HighlightSemanticTokens),
 		HoverDiagnostics:                  boolVal(c.HoverDiagnostics, d.HoverDiagnostics),
 		CompletionDeepCompletions:         boolVal(c.CompletionDeepCompletions, d.CompletionDeepCompletions),
 		CompletionMatcher:                 c.CompletionMatcher,
+		CompletionSnippets:                boolVal(c.CompletionSnippets, d.CompletionSnippets),
+		CompletionAsync:                   boolVal(c.CompletionAsync, d.CompletionAsync),
+		CompletionLazyDocumentation:       boolVal(c.CompletionLazyDocumentation, d.CompletionLazyDocumentation),
+		CompletionKindIcons:               copyStringValMap(c.CompletionKindIcons, d.CompletionKindIcons),
+		SignatureHelpAuto:                 boolVal(c.SignatureHelpAuto, d.SignatureHelpAuto),
 		SymbolMatcher:                     c.SymbolMatcher,
 		SymbolStyle:                       c.SymbolStyle,
 		Staticcheck:                       boolVal(c.Staticcheck, d.Staticcheck),
@@ -56,8 +74,14 @@ func (c *VimConfig) ToConfig(d config.Config) config.Config {
 		TempModfile:                       boolVal(c.TempModfile, d.TempModfile),
 		GoplsEnv:                          copyStringValMap(c.GoplsEnv, d.GoplsEnv),
 		GoplsDirectoryFilters:             copyStringValSlice(c.GoplsDirectoryFilters, d.GoplsDirectoryFilters),
+		ExtraBuildConfigs:                 copyBuildConfigSlice(c.ExtraBuildConfigs, d.ExtraBuildConfigs),
 		Analyses:                          mergeBoolValMap(c.Analyses, d.Analyses),
+		InlayHints:                        mergeBoolValMap(c.InlayHints, d.InlayHints),
+		Folding:                           boolVal(c.Folding, d.Folding),
 		OpenLastProgressWith:              stringVal(c.OpenLastProgressWith, d.OpenLastProgressWith),
+		OpenExternalWith:                  stringVal(c.OpenExternalWith, d.OpenExternalWith),
+		GoplsRemote:                       stringVal(c.GoplsRemote, d.GoplsRemote),
+		VulncheckDB:                       stringVal(c.VulncheckDB, d.VulncheckDB),
 		Gofumpt:                           boolVal(c.Gofumpt, d.Gofumpt),
 		ExperimentalAutoreadLoadedBuffers: boolVal(c.ExperimentalAutoreadLoadedBuffers, d.ExperimentalAutoreadLoadedBuffers),
 		ExperimentalMouseTriggeredHoverPopupOptions:  copyMap(c.ExperimentalMouseTriggeredHoverPopupOptions, d.ExperimentalMouseTriggeredHoverPopupOptions),
@@ -129,6 +153,22 @@ func copyStringValSlice(i, j *[]string) *[]string {
 	return &res
 }
 
+func copyBuildConfigSlice(i, j *[]config.BuildConfig) *[]config.BuildConfig {
+	toCopy := i
+	if i == nil {
+		toCopy = j
+		if j == nil {
+			return nil
+		}
+	}
+	res := make([]config.BuildConfig, len(*toCopy))
+	for k, bc := range *toCopy {
+		bc.Tags = append([]string(nil), bc.Tags...)
+		res[k] = bc
+	}
+	return &res
+}
+
 // mergeBoolValMap returns the union of i and j where conflicting keys use
 // the value from i.
 func mergeBoolValMap(i *map[string]int, j *map[string]bool) *map[string]bool {
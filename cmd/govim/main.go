@@ -162,13 +162,49 @@ type govimplugin struct {
 	goplsConn   jsonrpc2.Conn
 	goplsCancel context.CancelFunc
 	goplsStdin  io.WriteCloser
-	server      protocol.Server
+
+	// server is the current gopls instance, which is replaced when gopls is
+	// restarted. It is only ever set on the vim thread, where it can be read
+	// directly. Other goroutines must use goplsServer, which reads server
+	// under serverLock.
+	server     protocol.Server
+	serverLock sync.Mutex
+
+	// goplsCapabilities are the capabilities reported by gopls when it was
+	// last initialised
+	goplsCapabilities protocol.ServerCapabilities
+
+	// goplsStopped is closed when we stop the current gopls instance, such
+	// that its exit is not treated as a crash. goplsExited is closed when the
+	// current instance exits.
+	goplsStopped chan struct{}
+	goplsExited  chan struct{}
+
+	// goplsStarted is when we last started gopls
+	goplsStarted time.Time
+
+	// goplsRestarts is the number of times in a row we have restarted gopls
+	// following a crash
+	goplsRestarts int
+
+	// buildConfigs are the gopls instances for config.Config.ExtraBuildConfigs
+	buildConfigs []*buildConfigGopls
 
 	isGui bool
 
+	// hasVirtualText indicates whether Vim supports text properties with
+	// virtual text, i.e. the "text" argument to prop_add()
+	hasVirtualText bool
+
+	// hasAbbrHlgroup indicates whether Vim supports the "abbr_hlgroup" key of
+	// complete-items, used to strike through deprecated completion items
+	hasAbbrHlgroup bool
+
 	tomb tomb.Tomb
 
-	modWatcher *modWatcher
+	// dirWatchers are the file system watchers that satisfy the watchers
+	// registered by gopls, keyed by root directory
+	dirWatchers map[string]*dirWatcher
 
 	// diagnosticsChangedLock protects access to rawDiagnostics,
 	// diagnosticsChanged, diagnosticsChangedQuickfix,
@@ -203,6 +239,18 @@ type govimplugin struct {
 	cancelDocHighlight     context.CancelFunc
 	cancelDocHighlightLock sync.Mutex
 
+	// cancelInlayHints is the function to cancel the ongoing LSP inlayHint
+	// calls. It must be called before assigning a new value (or nil) to it. It
+	// is nil when there are no ongoing calls.
+	cancelInlayHints     context.CancelFunc
+	cancelInlayHintsLock sync.Mutex
+
+	// cancelSemanticTokens is the function to cancel the ongoing LSP
+	// semanticTokens calls. It must be called before assigning a new value (or
+	// nil) to it. It is nil when there are no ongoing calls.
+	cancelSemanticTokens     context.CancelFunc
+	cancelSemanticTokensLock sync.Mutex
+
 	// applyEditsCh is used to pass incoming edit requests (ApplyEdit) to the main thread.
 	// Incoming ApplyEdit calls will use this channel if set (not nil) instead of schedule
 	// edits directly. It is used to allow process edits during a blocking call on the vim
@@ -210,6 +258,14 @@ type govimplugin struct {
 	applyEditsCh   chan applyEditCall
 	applyEditsLock sync.Mutex
 
+	// workspaceFolders are the directories of the workspace folders known to
+	// gopls. The first is derived from the working directory when gopls is
+	// started; others are added and removed as buffers from other modules come
+	// and go. Access is protected by workspaceFoldersLock because gopls can
+	// ask for the folders at any time.
+	workspaceFolders     []string
+	workspaceFoldersLock sync.Mutex
+
 	bufferUpdates chan *bufferUpdate
 
 	// inShutdown is closed when govim is told to Shutdown
@@ -245,12 +301,21 @@ func newplugin(goplspath string, goplsEnv []string, defaults, user *config.Confi
 			Staticcheck:                       vimconfig.BoolVal(false),
 			HighlightDiagnostics:              vimconfig.BoolVal(true),
 			HighlightReferences:               vimconfig.BoolVal(true),
+			HighlightSemanticTokens:           vimconfig.BoolVal(false),
+			Folding:                           vimconfig.BoolVal(false),
 			HoverDiagnostics:                  vimconfig.BoolVal(true),
+			CompletionSnippets:                vimconfig.BoolVal(false),
+			CompletionAsync:                   vimconfig.BoolVal(false),
+			CompletionLazyDocumentation:       vimconfig.BoolVal(false),
+			SignatureHelpAuto:                 vimconfig.BoolVal(false),
 			TempModfile:                       vimconfig.BoolVal(false),
 			ExperimentalAutoreadLoadedBuffers: vimconfig.BoolVal(false),
 			SymbolMatcher:                     vimconfig.SymbolMatcherVal(config.SymbolMatcherFuzzy),
 			SymbolStyle:                       vimconfig.SymbolStyleVal(config.SymbolStyleFull),
 			OpenLastProgressWith:              vimconfig.StringVal("below 10split"),
+			OpenExternalWith:                  vimconfig.StringVal(defaultOpenExternalWith()),
+			GoplsRemote:                       vimconfig.StringVal(""),
+			VulncheckDB:                       vimconfig.StringVal(""),
 		}
 	}
 	// Overlay the initial user values on the defaults
@@ -268,13 +333,20 @@ func newplugin(goplspath string, goplsEnv []string, defaults, user *config.Confi
 		Driver:           d,
 		inShutdown:       make(chan struct{}),
 		diagnosticsCache: &emptyDiags,
+		dirWatchers:      make(map[string]*dirWatcher),
 		vimstate: &vimstate{
 			Driver:               d,
 			buffers:              make(map[int]*types.Buffer),
 			defaultConfig:        *defaults,
 			config:               *defaults,
 			suggestedFixesPopups: make(map[int][]suggestedFix),
+			messageRequestPopups: make(map[int]chan<- int),
+			workspaceRoots:       make(map[string]string),
 			progressPopups:       make(map[protocol.ProgressToken]*types.ProgressPopup),
+			hierarchies:          make(map[int]*hierarchy),
+			semanticTokens:       make(map[int]*semanticTokens),
+			folds:                make(map[int]*bufferFolds),
+			selectionStacks:      make(map[int]*selectionStack),
 		},
 	}
 	res.vimstate.govimplugin = res
@@ -297,12 +369,14 @@ func (g *govimplugin) Init(gg govim.Govim, errCh chan error) error {
 	g.DefineAutoCommand("", govim.Events{govim.EventBufRead, govim.EventBufNewFile}, govim.Patterns{"*.go", "go.mod", "go.sum"}, false, g.vimstate.bufReadPost, exprAutocmdCurrBufInfo)
 	g.DefineAutoCommand("", govim.Events{govim.EventBufWritePre}, govim.Patterns{"*.go", "go.mod", "go.sum"}, false, g.vimstate.formatCurrentBuffer, "eval(expand('<abuf>'))")
 	g.DefineAutoCommand("", govim.Events{govim.EventBufWritePost}, govim.Patterns{"*.go", "go.mod", "go.sum"}, false, g.vimstate.bufWritePost, "eval(expand('<abuf>'))")
+	g.DefineAutoCommand("", govim.Events{govim.EventDirChanged}, govim.Patterns{"global"}, false, g.vimstate.dirChanged, "getcwd(-1)")
 	g.DefineAutoCommand("", govim.Events{govim.EventQuickFixCmdPre}, govim.Patterns{"*vimgrep*"}, false, g.vimstate.bufQuickFixCmdPre)
 	g.DefineAutoCommand("", govim.Events{govim.EventQuickFixCmdPost}, govim.Patterns{"*vimgrep*"}, false, g.vimstate.bufQuickFixCmdPost)
 	g.DefineFunction(string(config.FunctionComplete), []string{"findarg", "base"}, g.vimstate.complete)
 	g.DefineCommand(string(config.CommandGoToDef), g.vimstate.gotoDef, govim.NArgsZeroOrOne)
 	g.DefineCommand(string(config.CommandGoToTypeDef), g.vimstate.gotoTypeDef, govim.NArgsZeroOrOne)
 	g.DefineCommand(string(config.CommandSuggestedFixes), g.vimstate.suggestFixes, govim.NArgsZeroOrOne)
+	g.DefineCommand(string(config.CommandCodeAction), g.vimstate.codeAction, govim.RangeLine)
 	g.DefineCommand(string(config.CommandGoToPrevDef), g.vimstate.gotoPrevDef, govim.NArgsZeroOrOne, govim.CountN(1))
 	g.DefineFunction(string(config.FunctionHover), []string{}, g.vimstate.hover)
 	g.DefineAutoCommand("", govim.Events{govim.EventBufDelete}, govim.Patterns{"*.go", "go.mod", "go.sum"}, false, g.vimstate.bufDelete, "eval(expand('<abuf>'))")
@@ -318,6 +392,8 @@ func (g *govimplugin) Init(gg govim.Govim, errCh chan error) error {
 	g.DefineCommand(string(config.CommandReferences), g.vimstate.references)
 	g.DefineCommand(string(config.CommandImplements), g.vimstate.implements)
 	g.DefineCommand(string(config.CommandRename), g.vimstate.rename, govim.NArgsZeroOrOne)
+	g.DefineCommand(string(config.CommandMoveFile), g.vimstate.moveFile, govim.NArgsOneOrMore, govim.CompleteFile)
+	g.DefineAutoCommand("", govim.Events{govim.EventBufFilePost}, govim.Patterns{"*.go", "go.mod", "go.sum"}, false, g.vimstate.bufFilePost, "eval(expand('<abuf>'))", "fnamemodify(bufname(eval(expand('<abuf>'))),':p')", "s:bufFileDepth > 0")
 	g.DefineCommand(string(config.CommandStringFn), g.vimstate.stringfns, govim.RangeLine, govim.CompleteCustomList(PluginPrefix+config.FunctionStringFnComplete), govim.NArgsOneOrMore)
 	g.DefineFunction(string(config.FunctionStringFnComplete), []string{"ArgLead", "CmdLine", "CursorPos"}, g.vimstate.stringfncomplete)
 	g.DefineCommand(string(config.CommandHighlightReferences), g.vimstate.highlightReferences)
@@ -325,11 +401,19 @@ func (g *govimplugin) Init(gg govim.Govim, errCh chan error) error {
 	g.DefineAutoCommand("", govim.Events{govim.EventCompleteDone}, govim.Patterns{"*.go", "go.mod", "go.sum"}, false, g.vimstate.completeDone, "eval(expand('<abuf>'))", "v:completed_item")
 	g.DefineFunction(string(config.FunctionParentCommand), []string{}, g.vimstate.parentCommand)
 	g.DefineCommand(string(config.CommandExperimentalSignatureHelp), g.vimstate.signatureHelp)
+	g.DefineFunction(string(config.FunctionSignatureHelpCycle), []string{}, g.vimstate.signatureHelpCycle)
 	g.DefineCommand(string(config.CommandFillStruct), g.vimstate.fillStruct)
 	g.DefineCommand(string(config.CommandGCDetails), g.vimstate.toggleGCDetails)
 	g.DefineCommand(string(config.CommandGoTest), g.vimstate.runGoTest, govim.RangeLine)
+	g.DefineCommand(string(config.CommandRunTests), g.vimstate.runTests, govim.RangeLine)
+	g.DefineCommand(string(config.CommandRerunTests), g.vimstate.rerunTests, govim.NArgsZeroOrOne)
+	g.DefineCommand(string(config.CommandCoverage), g.vimstate.toggleCoverage)
 	g.DefineFunction(string(config.FunctionProgressClosed), []string{"id", "selected"}, g.vimstate.progressClosed)
 	g.DefineCommand(string(config.CommandLastProgress), g.vimstate.openLastProgress)
+	g.DefineCommand(string(config.CommandCancelProgress), g.vimstate.cancelProgress, govim.CompleteCustomList(PluginPrefix+config.FunctionProgressTokenComplete), govim.NArgsZeroOrOne)
+	g.DefineFunction(string(config.FunctionProgressTokenComplete), []string{"ArgLead", "CmdLine", "CursorPos"}, g.vimstate.progressTokenComplete)
+	g.DefineCommand(string(config.CommandRestartGopls), g.vimstate.restartGopls)
+	g.DefineCommand(string(config.CommandVulncheck), g.vimstate.vulncheck, govim.NArgsZeroOrOne)
 	g.defineHighlights()
 	if err := g.vimstate.signDefine(); err != nil {
 		return fmt.Errorf("failed to define signs: %v", err)
@@ -338,12 +422,27 @@ func (g *govimplugin) Init(gg govim.Govim, errCh chan error) error {
 		return fmt.Errorf("failed to defined text property types: %v", err)
 	}
 	g.DefineFunction(string(config.FunctionMotion), []string{"direction", "target"}, g.vimstate.motion)
+	g.DefineFunction(string(config.FunctionExpandSelection), []string{}, g.vimstate.expandSelection)
+	g.DefineFunction(string(config.FunctionShrinkSelection), []string{}, g.vimstate.shrinkSelection)
+	g.DefineFunction(string(config.FunctionSnippetNext), []string{}, g.vimstate.snippetNext)
+	g.DefineFunction(string(config.FunctionSnippetPrev), []string{}, g.vimstate.snippetPrev)
+	g.DefineCommand(string(config.CommandCallHierarchy), g.vimstate.callHierarchy, govim.NArgsZeroOrOne)
+	g.DefineCommand(string(config.CommandTypeHierarchy), g.vimstate.typeHierarchy)
+	g.DefineFunction(string(config.FunctionHierarchyToggle), []string{"bufnr", "line"}, g.vimstate.hierarchyToggle)
+	g.DefineFunction(string(config.FunctionHierarchyJump), []string{"bufnr", "line"}, g.vimstate.hierarchyJump)
+	g.DefineAutoCommand("", govim.Events{govim.EventBufWinEnter}, govim.Patterns{"*.go"}, false, g.vimstate.bufWinEnter, "eval(expand('<abuf>'))")
+	if g.ParseInt(g.ChannelExpr(`exists("##WinScrolled")`)) == 1 {
+		// WinScrolled matches against window IDs rather than file names
+		g.DefineAutoCommand("", govim.Events{govim.EventWinScrolled}, govim.Patterns{"*"}, false, g.vimstate.viewportChanged)
+	}
 
 	g.startProcessBufferUpdates()
 
 	g.InitTestAPI()
 
 	g.isGui = g.ParseInt(g.ChannelExpr(`has("gui_running")`)) == 1
+	g.hasVirtualText = g.ParseInt(g.ChannelExpr(`has("patch-9.0.0067")`)) == 1
+	g.hasAbbrHlgroup = g.ParseInt(g.ChannelExpr(`has("patch-9.1.1000")`)) == 1
 
 	if err := g.startGopls(); err != nil {
 		return err
@@ -415,7 +514,7 @@ func (g *govimplugin) Shutdown() error {
 	// around, and not have properly tidied up after itself.
 	ctxt, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer cancel()
-	if err := g.server.Shutdown(ctxt); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+	if err := g.goplsServer().Shutdown(ctxt); err != nil && !errors.Is(err, context.DeadlineExceeded) {
 		return fmt.Errorf("failed to call gopls Shutdown: %v", err)
 	}
 	// As the initiator of the connection to gopls, complete shutdown by closing
@@ -423,14 +522,10 @@ func (g *govimplugin) Shutdown() error {
 	if err := g.goplsStdin.Close(); err != nil {
 		return fmt.Errorf("failed to close gopls stdin: %v", err)
 	}
+	g.stopBuildConfigGopls()
 
 	// Shutdown the filewatcher
-	if g.modWatcher != nil {
-		if err := g.modWatcher.close(); err != nil {
-			return fmt.Errorf("failed to close file watcher: %v", err)
-		}
-	}
-	return nil
+	return g.closeDirWatchers()
 }
 
 func (g *govimplugin) defineHighlights() {
@@ -451,6 +546,9 @@ func (g *govimplugin) defineHighlights() {
 		fmt.Sprintf("highlight default %s ctermfg=15 ctermbg=%d guisp=Orange guifg=Orange", config.HighlightSignWarn, warnColor),
 		fmt.Sprintf("highlight default %s ctermfg=15 ctermbg=6 guisp=Cyan guifg=Cyan", config.HighlightSignInfo),
 		fmt.Sprintf("highlight default link %s %s", config.HighlightSignHint, config.HighlightSignInfo),
+		fmt.Sprintf("highlight default link %s %s", config.HighlightSignTestPass, config.HighlightGoTestPass),
+		fmt.Sprintf("highlight default link %s %s", config.HighlightSignTestFail, config.HighlightGoTestFail),
+		fmt.Sprintf("highlight default link %s Comment", config.HighlightSignTestSkip),
 
 		fmt.Sprintf("highlight default %s cterm=bold gui=bold ctermfg=1", config.HighlightHoverErr),
 		fmt.Sprintf("highlight default %s cterm=bold gui=bold ctermfg=%d", config.HighlightHoverWarn, warnColor),
@@ -464,6 +562,24 @@ func (g *govimplugin) defineHighlights() {
 		fmt.Sprintf("highlight default link %s PMenu", config.HighlightSignature),
 		fmt.Sprintf("highlight default %s term=bold cterm=bold gui=bold", config.HighlightSignatureParam),
 
+		fmt.Sprintf("highlight default link %s Comment", config.HighlightInlayHint),
+		fmt.Sprintf("highlight default link %s WarningMsg", config.HighlightVulncheck),
+		fmt.Sprintf("highlight default link %s DiffAdd", config.HighlightCovered),
+		fmt.Sprintf("highlight default link %s DiffDelete", config.HighlightUncovered),
+		fmt.Sprintf("highlight default link %s Comment", config.HighlightCoverageSummary),
+		fmt.Sprintf("highlight default link %s Underlined", config.HighlightSnippetPlaceholder),
+		fmt.Sprintf("highlight default %s term=strikethrough cterm=strikethrough gui=strikethrough", config.HighlightCompletionDeprecated),
+
+		fmt.Sprintf("highlight default link %s Include", config.HighlightSemanticNamespace),
+		fmt.Sprintf("highlight default link %s Type", config.HighlightSemanticType),
+		fmt.Sprintf("highlight default link %s %s", config.HighlightSemanticInterface, config.HighlightSemanticType),
+		fmt.Sprintf("highlight default link %s Special", config.HighlightSemanticTypeParameter),
+		fmt.Sprintf("highlight default link %s Identifier", config.HighlightSemanticParameter),
+		fmt.Sprintf("highlight default %s term=NONE cterm=NONE gui=NONE", config.HighlightSemanticVariable),
+		fmt.Sprintf("highlight default link %s Constant", config.HighlightSemanticReadonlyVariable),
+		fmt.Sprintf("highlight default link %s Function", config.HighlightSemanticFunction),
+		fmt.Sprintf("highlight default link %s %s", config.HighlightSemanticMethod, config.HighlightSemanticFunction),
+
 		fmt.Sprintf("highlight default %s ctermfg=2 guifg=Green", config.HighlightGoTestPass),
 		fmt.Sprintf("highlight default %s ctermfg=1 guifg=Red ", config.HighlightGoTestFail),
 	} {
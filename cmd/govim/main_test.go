@@ -125,6 +125,7 @@ func TestScripts(t *testing.T) {
 						"HOME="+home,
 						"GOPATH="+filepath.Join(home, "gopath"),
 						"PLUGIN_PATH="+govimPath,
+						"GOPLS_PATH="+goplsPath,
 					)
 					if workdir != "" {
 						e.Vars = append(e.Vars, "GOVIM_LOGFILE_TMPL=%v")
@@ -318,7 +318,7 @@ func (g *goplsSymbolCmd) Run() {
 	symbolReq := &protocol.WorkspaceSymbolParams{
 		Query: query,
 	}
-	symbolResp, err := g.server.Symbol(context.Background(), symbolReq)
+	symbolResp, err := g.goplsServer().Symbol(context.Background(), symbolReq)
 	if err != nil {
 		g.Errorf("failed to call gopls.Symbol: %v", err)
 	}
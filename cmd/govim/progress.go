@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -8,6 +10,7 @@ import (
 
 	"github.com/govim/govim"
 	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
 	"github.com/govim/govim/cmd/govim/internal/types"
 )
 
@@ -57,6 +60,7 @@ func (v *vimstate) handleProgress(popup *types.ProgressPopup, kind, title, messa
 		v.BatchChannelCall("popup_setoptions", popup.ID, opts)
 		v.MustBatchEnd()
 	case "end":
+		popup.Ended = true
 		opts := map[string]interface{}{
 			"time":      3000, // close after 3 seconds, as popup_notification()
 			"firstline": firstline,
@@ -148,3 +152,62 @@ func (v *vimstate) openLastProgress(flags govim.CommandFlags, args ...string) er
 	}
 	return nil
 }
+
+// cancellableProgress returns the tokens of the ongoing work that can be
+// cancelled, keyed by their string representation.
+func (v *vimstate) cancellableProgress() map[string]protocol.ProgressToken {
+	res := make(map[string]protocol.ProgressToken)
+	for token, popup := range v.progressPopups {
+		if popup != nil && popup.Cancellable && !popup.Ended {
+			res[fmt.Sprint(token)] = token
+		}
+	}
+	return res
+}
+
+func (v *vimstate) cancelProgress(flags govim.CommandFlags, args ...string) error {
+	tokens := v.cancellableProgress()
+	if len(args) == 1 {
+		token, ok := tokens[args[0]]
+		if !ok {
+			return fmt.Errorf("no cancellable progress with token %q", args[0])
+		}
+		return v.cancelProgressToken(token)
+	}
+	if len(tokens) == 0 {
+		v.ChannelEx(`echom "No cancellable progress"`)
+		return nil
+	}
+	var keys []string
+	for k := range tokens {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v.ChannelExf("echom %q", k+": "+v.progressPopups[tokens[k]].Title)
+	}
+	return nil
+}
+
+func (v *vimstate) progressTokenComplete(args ...json.RawMessage) (interface{}, error) {
+	lead := v.ParseString(args[0])
+	results := []string{}
+	for k := range v.cancellableProgress() {
+		if strings.HasPrefix(k, lead) {
+			results = append(results, k)
+		}
+	}
+	sort.Strings(results)
+	return results, nil
+}
+
+// cancelProgressToken asks gopls to cancel the work reported via token.
+func (v *vimstate) cancelProgressToken(token protocol.ProgressToken) error {
+	err := v.server.WorkDoneProgressCancel(context.Background(), &protocol.WorkDoneProgressCancelParams{
+		Token: token,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to cancel progress %v: %v", token, err)
+	}
+	return nil
+}
@@ -38,11 +38,37 @@ func (v *vimstate) rename(flags govim.CommandFlags, args ...string) error {
 }
 
 func (v *vimstate) applyMultiBufTextedits(splitMods govim.CommModList, changes []protocol.DocumentChanges) error {
-	allChanges := changes
-	if len(allChanges) == 0 {
+	if len(changes) == 0 {
 		v.Logf("No changes to apply for rename")
 		return nil
 	}
+	if err := v.checkRenamesUnsaved(documentChanges(changes)); err != nil {
+		return err
+	}
+	// Resource operations (e.g. file renames) apply to the result of the
+	// changes that precede them, so the text edits between them are applied
+	// in order.
+	var edits []protocol.TextDocumentEdit
+	for _, c := range changes {
+		if c.TextDocumentEdit != nil {
+			edits = append(edits, *c.TextDocumentEdit)
+			continue
+		}
+		if err := v.applyTextDocumentEdits(splitMods, edits); err != nil {
+			return err
+		}
+		edits = nil
+		if err := v.applyResourceOperation(documentChange{RenameFile: c.RenameFile}); err != nil {
+			return err
+		}
+	}
+	return v.applyTextDocumentEdits(splitMods, edits)
+}
+
+func (v *vimstate) applyTextDocumentEdits(splitMods govim.CommModList, edits []protocol.TextDocumentEdit) error {
+	if len(edits) == 0 {
+		return nil
+	}
 	// TODO: it feels like we need a new config variable for the strategy to use
 	// when making edits of this sort (to multiple files). It doesn't feel right
 	// to use the value of &switchbuf because there might be multiple changes
@@ -52,15 +78,9 @@ func (v *vimstate) applyMultiBufTextedits(splitMods govim.CommModList, changes [
 	bufNrs := make(map[string]int)
 	var fps []string
 	uriMap := make(map[protocol.DocumentURI]protocol.TextDocumentEdit)
-	for _, c := range allChanges {
-		// TODO: protocol.DocumentChanges is a union that (currently) must have
-		// either TextDocumentEdit or RenameFile set. We should add support for
-		// renaming files as well.
-		if c.TextDocumentEdit == nil {
-			return fmt.Errorf("file renaming not supported")
-		}
-		uriMap[c.TextDocumentEdit.TextDocument.TextDocumentIdentifier.URI] = *c.TextDocumentEdit
-		fps = append(fps, string(c.TextDocumentEdit.TextDocument.TextDocumentIdentifier.URI))
+	for _, e := range edits {
+		uriMap[e.TextDocument.TextDocumentIdentifier.URI] = e
+		fps = append(fps, string(e.TextDocument.TextDocumentIdentifier.URI))
 	}
 	// So that we have reproducible behaviour
 	sort.Strings(fps)
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"unicode/utf8"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// selectionStack records the successive selections made in a window by
+// expandSelection, such that shrinkSelection can retrace them.
+type selectionStack struct {
+	buf     *types.Buffer
+	version int32
+
+	// ranges are the selections, the last of which is the current one. The
+	// first is the selection from which the first expansion was made.
+	ranges []protocol.Range
+}
+
+func (v *vimstate) expandSelection(args ...json.RawMessage) (interface{}, error) {
+	winid, b, sel, err := v.visualSelection(config.FunctionExpandSelection)
+	if err != nil {
+		return nil, err
+	}
+	s := v.selectionStack(winid, b, sel)
+	ranges, err := v.selectionRanges(b, sel.Start)
+	if err != nil {
+		// Restore the selection that was lost by calling us
+		v.selectRange(b, sel)
+		return nil, err
+	}
+	// Select the innermost range that strictly encloses the current selection
+	for _, r := range ranges {
+		if protocol.ComparePosition(r.Start, sel.Start) <= 0 &&
+			protocol.ComparePosition(r.End, sel.End) >= 0 &&
+			r != sel {
+			s.ranges = append(s.ranges, r)
+			return nil, v.selectRange(b, r)
+		}
+	}
+	// Nothing bigger to select, so keep the current selection
+	return nil, v.selectRange(b, sel)
+}
+
+// selectionRanges returns the ranges of the syntax nodes that enclose pos in
+// b, innermost first. Versions of gopls that do not support
+// textDocument/selectionRange are handled by finding the nodes in the AST of
+// b instead.
+func (v *vimstate) selectionRanges(b *types.Buffer, pos protocol.Position) ([]protocol.Range, error) {
+	var res []protocol.Range
+	if providerSupported(v.goplsCapabilities.SelectionRangeProvider) {
+		sr, err := v.server.SelectionRange(context.Background(), &protocol.SelectionRangeParams{
+			TextDocument: b.ToTextDocumentIdentifier(),
+			Positions:    []protocol.Position{pos},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("call to gopls.SelectionRange failed: %v", err)
+		}
+		if len(sr) > 0 {
+			for r := &sr[0]; r != nil; r = r.Parent {
+				res = append(res, r.Range)
+			}
+		}
+		return res, nil
+	}
+	p, err := types.PointFromPosition(b, pos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve selection position: %v", err)
+	}
+	<-b.ASTWait
+	var file *token.File
+	b.Fset.Iterate(func(f *token.File) bool {
+		if f.Name() == b.Name {
+			file = f
+			return false
+		}
+		panic(fmt.Errorf("expected to find a single file in the fset"))
+	})
+	tp := file.Pos(p.Offset())
+	path, _ := astutil.PathEnclosingInterval(b.AST, tp, tp)
+	for _, n := range path {
+		start, err := types.PointFromOffset(b, file.Offset(n.Pos()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve start of node: %v", err)
+		}
+		end, err := types.PointFromOffset(b, file.Offset(n.End()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve end of node: %v", err)
+		}
+		r := protocol.Range{Start: start.ToPosition(), End: end.ToPosition()}
+		if len(res) == 0 || res[len(res)-1] != r {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+func (v *vimstate) shrinkSelection(args ...json.RawMessage) (interface{}, error) {
+	winid, b, sel, err := v.visualSelection(config.FunctionShrinkSelection)
+	if err != nil {
+		return nil, err
+	}
+	s := v.selectionStack(winid, b, sel)
+	if len(s.ranges) > 1 {
+		s.ranges = s.ranges[:len(s.ranges)-1]
+	}
+	return nil, v.selectRange(b, s.ranges[len(s.ranges)-1])
+}
+
+// selectionStack returns the selection stack of the window winid, which shows
+// b with the selection sel. The stack is reset if b has changed or sel is not
+// the selection last made via the stack, e.g. because the user has since made
+// a different selection.
+func (v *vimstate) selectionStack(winid int, b *types.Buffer, sel protocol.Range) *selectionStack {
+	s, ok := v.selectionStacks[winid]
+	if !ok || s.buf != b || s.version != b.Version || s.ranges[len(s.ranges)-1] != sel {
+		s = &selectionStack{
+			buf:     b,
+			version: b.Version,
+			ranges:  []protocol.Range{sel},
+		}
+		v.selectionStacks[winid] = s
+	}
+	return s
+}
+
+// visualSelection returns the window, buffer and range of the last visual
+// selection, for use by fn. It is expected to be called from a visual mode
+// mapping, at which point the selection is given by the '< and '> marks.
+func (v *vimstate) visualSelection(fn config.Function) (winid int, b *types.Buffer, sel protocol.Range, err error) {
+	var pos struct {
+		WinID   int    `json:"winid"`
+		BufNr   int    `json:"bufnr"`
+		Mode    string `json:"mode"`
+		Line1   int    `json:"line1"`
+		Line2   int    `json:"line2"`
+		End     []int  `json:"end"` // [bufnr, line, col, off]
+		EndLine string `json:"endline"`
+	}
+	v.Parse(v.ChannelExpr(`{"winid": win_getid(), "bufnr": bufnr(""), "mode": visualmode(), "line1": line("'<"), "line2": line("'>"), "end": getpos("'>"), "endline": getline("'>")}`), &pos)
+	b, ok := v.buffers[pos.BufNr]
+	if !ok {
+		return winid, b, sel, fmt.Errorf("failed to resolve buffer %v", pos.BufNr)
+	}
+	if pos.Mode == "\x16" { // <CTRL-V>, block-wise
+		return winid, b, sel, fmt.Errorf("cannot use %v in visual block mode", fn)
+	}
+	// A characterwise selection that includes the newline, e.g. via "$", has
+	// its end column past the end of the line. Move the '> mark to the last
+	// character for the benefit of rangeFromFlags, and put it back afterwards.
+	if pos.Mode == "v" && pos.End[2] > len(pos.EndLine) {
+		col := len(pos.EndLine)
+		if col == 0 {
+			col = 1
+		}
+		v.ChannelCall("setpos", "'>", []int{0, pos.End[1], col, 0})
+		defer v.ChannelCall("setpos", "'>", pos.End)
+	}
+	rangeType := 2
+	start, end, err := v.rangeFromFlags(b, govim.CommandFlags{Range: &rangeType, Line1: &pos.Line1, Line2: &pos.Line2})
+	if err != nil {
+		return winid, b, sel, err
+	}
+	return pos.WinID, b, protocol.Range{Start: start.ToPosition(), End: end.ToPosition()}, nil
+}
+
+// selectRange makes r the characterwise visual selection in the current
+// window, which shows b.
+func (v *vimstate) selectRange(b *types.Buffer, r protocol.Range) error {
+	start, err := types.PointFromPosition(b, r.Start)
+	if err != nil {
+		return fmt.Errorf("failed to convert start of selection: %v", err)
+	}
+	end, err := types.PointFromPosition(b, r.End)
+	if err != nil {
+		return fmt.Errorf("failed to convert end of selection: %v", err)
+	}
+	// The visual selection is inclusive, so select up to the last character
+	// before the end of r, ignoring a trailing newline.
+	if end.Offset() > start.Offset() {
+		prev := b.Contents()[:end.Offset()]
+		if bytes.HasSuffix(prev, []byte("\n")) && end.Offset()-1 > start.Offset() {
+			prev = prev[:len(prev)-1]
+		}
+		_, size := utf8.DecodeLastRune(prev)
+		end, err = types.PointFromOffset(b, len(prev)-size)
+		if err != nil {
+			return fmt.Errorf("failed to get inclusive end of selection: %v", err)
+		}
+	}
+	v.ChannelExf(`call cursor(%v, %v) | execute "normal! v" | call cursor(%v, %v)`, start.Line(), start.Col(), end.Line(), end.Col())
+	return nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+// semanticTokenTypes and semanticTokenModifiers are the token types and
+// modifiers advertised to gopls. We advertise the full set gopls knows about,
+// even though we only highlight some of them. Each token is encoded relative
+// to the previous token, whatever its type, and gopls omits the tokens of
+// types not advertised. But gopls takes the line of the first token it sends
+// from the first token it found, even if that token was omitted, which would
+// shift all tokens in a range that starts with a token of an omitted type.
+var (
+	semanticTokenTypes = []string{
+		"namespace", "type", "class", "enum", "interface",
+		"struct", "typeParameter", "parameter", "variable", "property", "enumMember",
+		"event", "function", "method", "macro", "keyword", "modifier", "comment",
+		"string", "number", "regexp", "operator",
+	}
+	semanticTokenModifiers = []string{
+		"declaration", "definition", "readonly", "static",
+		"deprecated", "abstract", "async", "modification", "documentation", "defaultLibrary",
+	}
+)
+
+// semanticTokenHighlights maps the semantic token types we highlight to the
+// highlight group used.
+var semanticTokenHighlights = map[string]config.Highlight{
+	"namespace":     config.HighlightSemanticNamespace,
+	"type":          config.HighlightSemanticType,
+	"interface":     config.HighlightSemanticInterface,
+	"typeParameter": config.HighlightSemanticTypeParameter,
+	"parameter":     config.HighlightSemanticParameter,
+	"variable":      config.HighlightSemanticVariable,
+	"function":      config.HighlightSemanticFunction,
+	"method":        config.HighlightSemanticMethod,
+}
+
+// semanticTokens is the full set of semantic tokens for a version of a
+// buffer, as last returned by gopls.
+type semanticTokens struct {
+	// buf is the buffer the tokens were requested for. We keep a reference to
+	// the buffer itself rather than relying on the buffer number in order to
+	// detect a buffer having been unloaded and reloaded.
+	buf *types.Buffer
+
+	version  int32
+	resultID string
+	data     []uint32
+}
+
+// semanticTokensResult is the result of requesting the semantic tokens for
+// some visible lines.
+type semanticTokensResult struct {
+	// full indicates that data covers the whole buffer, rather than only the
+	// visible lines.
+	full     bool
+	resultID string
+	data     []uint32
+
+	// noDelta indicates that the request for a delta failed.
+	noDelta bool
+}
+
+// semanticTokensEnabled returns true if semantic token highlighting is enabled
+// and gopls has registered support for it.
+func (v *vimstate) semanticTokensEnabled() bool {
+	return v.config.HighlightSemanticTokens != nil && *v.config.HighlightSemanticTokens &&
+		v.semanticTokensLegend != nil
+}
+
+// updateSemanticTokens requests semantic tokens for the visible lines of
+// buffers, highlighting them once gopls responds. Where possible the tokens
+// for a buffer are updated incrementally using the tokens last received.
+func (v *vimstate) updateSemanticTokens() error {
+	if !v.semanticTokensEnabled() {
+		return nil
+	}
+	visible := v.visibleBufferLines()
+	prev := make([]*semanticTokens, len(visible))
+	for i, l := range visible {
+		if st, ok := v.semanticTokens[l.buf.Num]; ok && st.buf == l.buf {
+			prev[i] = st
+		}
+	}
+	noDelta := v.semanticTokensNoDelta
+	ctx, cancel := context.WithCancel(context.Background())
+	// Cancel any ongoing requests to make sure that we only process the
+	// latest response.
+	v.cancelSemanticTokensLock.Lock()
+	if v.cancelSemanticTokens != nil {
+		v.cancelSemanticTokens()
+	}
+	v.cancelSemanticTokens = cancel
+	v.cancelSemanticTokensLock.Unlock()
+
+	v.tomb.Go(func() error {
+		v.redefineSemanticTokens(ctx, visible, prev, noDelta)
+		return nil
+	})
+	return nil
+}
+
+func (g *govimplugin) redefineSemanticTokens(ctx context.Context, visible []visibleLines, prev []*semanticTokens, noDelta bool) {
+	results := make([]semanticTokensResult, len(visible))
+	for i, l := range visible {
+		res, err := g.semanticTokensFor(ctx, l, prev[i], noDelta)
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if err != nil {
+			g.Logf("semantic tokens call failed: %v", err)
+			return
+		}
+		noDelta = noDelta || res.noDelta
+		results[i] = res
+	}
+
+	g.Schedule(func(govim.Govim) error {
+		// If the context is cancelled, a new request has or will soon be sent
+		// and this one is no longer relevant so we just return here.
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		return g.vimstate.handleSemanticTokens(visible, results)
+	})
+}
+
+// semanticTokensFor returns the semantic tokens for the visible lines l. If
+// prev is not nil it holds the last full set of tokens for the buffer, which
+// are reused or updated with a delta from gopls.
+func (g *govimplugin) semanticTokensFor(ctx context.Context, l visibleLines, prev *semanticTokens, noDelta bool) (semanticTokensResult, error) {
+	doc := l.buf.ToTextDocumentIdentifier()
+	if prev != nil && prev.version == l.version {
+		return semanticTokensResult{full: true, resultID: prev.resultID, data: prev.data}, nil
+	}
+	var res semanticTokensResult
+	if !noDelta {
+		if prev != nil && prev.resultID != "" {
+			delta, err := g.goplsServer().SemanticTokensFullDelta(ctx, &protocol.SemanticTokensDeltaParams{
+				TextDocument:     doc,
+				PreviousResultID: prev.resultID,
+			})
+			if err == nil {
+				var full semanticTokensResult
+				if full, err = semanticTokensFromDelta(prev.data, delta); err == nil {
+					return full, nil
+				}
+			}
+			g.Logf("semantic tokens delta not available, falling back to ranges: %v", err)
+			res.noDelta = true
+		} else {
+			full, err := g.goplsServer().SemanticTokensFull(ctx, &protocol.SemanticTokensParams{
+				TextDocument: doc,
+			})
+			if err == nil {
+				return semanticTokensResult{full: true, resultID: full.ResultID, data: full.Data}, nil
+			}
+			g.Logf("full semantic tokens not available, falling back to ranges: %v", err)
+		}
+	}
+	rng, err := g.goplsServer().SemanticTokensRange(ctx, &protocol.SemanticTokensRangeParams{
+		TextDocument: doc,
+		Range:        l.toRange(),
+	})
+	if err != nil {
+		return res, err
+	}
+	res.data = rng.Data
+	return res, nil
+}
+
+// semanticTokensFromDelta returns the full set of semantic tokens that result
+// from applying the result of a SemanticTokensFullDelta call to prev. delta
+// can either be a delta or a new full set of tokens. An error is returned if
+// delta cannot be decoded or applied.
+func semanticTokensFromDelta(prev []uint32, delta interface{}) (res semanticTokensResult, err error) {
+	// The result is untyped because of the union, so decode it via JSON
+	var d struct {
+		ResultID string                        `json:"resultId"`
+		Data     []uint32                      `json:"data"`
+		Edits    []protocol.SemanticTokensEdit `json:"edits"`
+	}
+	byts, err := json.Marshal(delta)
+	if err != nil {
+		return res, fmt.Errorf("failed to encode delta: %v", err)
+	}
+	if err := json.Unmarshal(byts, &d); err != nil {
+		return res, fmt.Errorf("failed to decode delta: %v", err)
+	}
+	if d.Edits == nil {
+		if d.Data == nil {
+			return res, fmt.Errorf("delta has neither edits nor data")
+		}
+		return semanticTokensResult{full: true, resultID: d.ResultID, data: d.Data}, nil
+	}
+	// Edits all refer to indices in prev, so apply them from the end
+	// backwards
+	sort.Slice(d.Edits, func(i, j int) bool {
+		return d.Edits[i].Start > d.Edits[j].Start
+	})
+	data := append([]uint32(nil), prev...)
+	for _, e := range d.Edits {
+		if int(e.Start+e.DeleteCount) > len(data) {
+			return res, fmt.Errorf("delta edit of %v tokens at %v is out of range of the %v previous tokens", e.DeleteCount, e.Start, len(prev))
+		}
+		tail := append(append([]uint32(nil), e.Data...), data[e.Start+e.DeleteCount:]...)
+		data = append(data[:e.Start], tail...)
+	}
+	return semanticTokensResult{full: true, resultID: d.ResultID, data: data}, nil
+}
+
+func (v *vimstate) handleSemanticTokens(visible []visibleLines, results []semanticTokensResult) error {
+	v.BatchStart()
+	defer v.BatchCancelIfNotEnded()
+	for i, l := range visible {
+		res := results[i]
+		v.semanticTokensNoDelta = v.semanticTokensNoDelta || res.noDelta
+		b := l.buf
+		if b.Version != l.version {
+			// The buffer has changed since the tokens were requested, which
+			// means the positions might no longer be valid. bufChanged will
+			// have triggered another request.
+			continue
+		}
+		if res.full {
+			v.semanticTokens[b.Num] = &semanticTokens{
+				buf:      b,
+				version:  l.version,
+				resultID: res.resultID,
+				data:     res.data,
+			}
+		}
+		v.applySemanticTokens(l, res.data)
+	}
+	v.MustBatchEnd()
+	return nil
+}
+
+// applySemanticTokens replaces the semantic token highlights in the visible
+// lines l with those described by data, encoded as per the LSP spec.
+func (v *vimstate) applySemanticTokens(l visibleLines, data []uint32) {
+	b := l.buf
+	v.BatchChannelCall("prop_remove", struct {
+		ID    int `json:"id"`
+		BufNr int `json:"bufnr"`
+		All   int `json:"all"`
+	}{types.SemanticTokenTextPropID, b.Num, 1}, l.start, l.end)
+
+	legend := v.semanticTokensLegend
+	var readonly uint32
+	for i, m := range legend.TokenModifiers {
+		if m == "readonly" {
+			readonly = 1 << uint(i)
+		}
+	}
+	var line, char uint32
+	for i := 0; i+4 < len(data); i += 5 {
+		if data[i] != 0 {
+			char = 0
+		}
+		line += data[i]
+		char += data[i+1]
+		if int(line) < l.start-1 || int(line) > l.end-1 {
+			continue
+		}
+		typ := int(data[i+3])
+		if typ >= len(legend.TokenTypes) {
+			continue
+		}
+		hi, ok := semanticTokenHighlights[legend.TokenTypes[typ]]
+		if !ok {
+			continue
+		}
+		if hi == config.HighlightSemanticVariable && data[i+4]&readonly != 0 {
+			hi = config.HighlightSemanticReadonlyVariable
+		}
+		start, err := types.PointFromPosition(b, protocol.Position{Line: line, Character: char})
+		if err != nil {
+			v.Logf("failed to convert semantic token start to point: %v", err)
+			continue
+		}
+		end, err := types.PointFromPosition(b, protocol.Position{Line: line, Character: char + data[i+2]})
+		if err != nil {
+			v.Logf("failed to convert semantic token end to point: %v", err)
+			continue
+		}
+		v.BatchAssertChannelCall(assertPropAdd, "prop_add",
+			start.Line(),
+			start.Col(),
+			propAddDict{string(hi), types.SemanticTokenTextPropID, end.Line(), end.Col(), b.Num},
+		)
+	}
+}
+
+// removeSemanticTokens removes the semantic token highlights from all
+// buffers, cancels any ongoing requests and forgets the tokens last received.
+func (v *vimstate) removeSemanticTokens() {
+	v.cancelSemanticTokensLock.Lock()
+	if v.cancelSemanticTokens != nil {
+		v.cancelSemanticTokens()
+		v.cancelSemanticTokens = nil
+	}
+	v.cancelSemanticTokensLock.Unlock()
+
+	v.semanticTokens = make(map[int]*semanticTokens)
+	v.removeTextProps(types.SemanticTokenTextPropID)
+}
@@ -48,13 +48,6 @@ func (v *vimstate) signatureHelp(flags govim.CommandFlags, args ...string) error
 	}
 	sig := res.Signatures[sigInx]
 
-	var activeParam string
-	// According to LSP Specification 3.15 the server might send an active parameter index
-	// that is outside the range of parameters sent so we need to ensure it exists here.
-	if i := int(res.ActiveParameter); i < len(sig.Parameters) {
-		activeParam = sig.Parameters[i].Label
-	}
-
 	// Use our locally parsed AST to find where to place this signature
 	<-b.ASTWait
 	var file *token.File
@@ -125,28 +118,39 @@ FindCall:
 	opts["col"] = screenPos.Col - 1
 	opts["close"] = "click"
 
-	// formatPopupLine applies text properties to a signature help line and the active
-	// parameter (if found).
-	formatPopupLine := func(text, param string) types.PopupLine {
-		sigProp := string(config.HighlightSignature)
-		paramProp := string(config.HighlightSignatureParam)
+	lines := signatureLines(sig, activeParameter(res, sig))
+	v.ChannelCall("popup_create", lines, opts)
+
+	return nil
+}
+
+// activeParameter returns the label of the active parameter of sig, or "" if
+// there is none.
+func activeParameter(res *protocol.SignatureHelp, sig protocol.SignatureInformation) string {
+	// According to LSP Specification 3.15 the server might send an active parameter index
+	// that is outside the range of parameters sent so we need to ensure it exists here.
+	if i := int(res.ActiveParameter); i < len(sig.Parameters) {
+		return sig.Parameters[i].Label
+	}
+	return ""
+}
+
+// signatureLines returns the popup lines for sig, with text properties
+// applied to the signature and the active parameter (if found).
+func signatureLines(sig protocol.SignatureInformation, activeParam string) []types.PopupLine {
+	sigProp := string(config.HighlightSignature)
+	paramProp := string(config.HighlightSignatureParam)
+	var lines []types.PopupLine
+	for _, text := range strings.Split(sig.Label, "\n") {
 		popupLine := types.PopupLine{
 			Text:  text,
 			Props: []types.PopupProp{{Type: sigProp, Col: 1, Len: len(text)}},
 		}
-
-		if i := strings.Index(text, param); param != "" && i >= 0 {
+		if i := strings.Index(text, activeParam); activeParam != "" && i >= 0 {
 			popupLine.Props = append(popupLine.Props,
-				types.PopupProp{Type: paramProp, Col: i + 1, Len: len(param)})
+				types.PopupProp{Type: paramProp, Col: i + 1, Len: len(activeParam)})
 		}
-		return popupLine
+		lines = append(lines, popupLine)
 	}
-
-	var lines []types.PopupLine
-	for _, l := range strings.Split(sig.Label, "\n") {
-		lines = append(lines, formatPopupLine(l, activeParam))
-	}
-	v.ChannelCall("popup_create", lines, opts)
-
-	return nil
+	return lines
 }
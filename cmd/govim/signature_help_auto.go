@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+// autoSignatureHelp is the state of signature help while typing the arguments
+// of a call, see SignatureHelpAuto.
+type autoSignatureHelp struct {
+	// pending is set when a character that starts or separates the arguments
+	// of a call is typed, such that signature help is requested once the
+	// character has been inserted
+	pending bool
+
+	// cancel cancels the SignatureHelp request in flight, if any
+	cancel context.CancelFunc
+
+	// popup is the ID of the signature help popup, or 0 if none is shown.
+	// bufnr is the buffer for which the popup is shown.
+	popup int
+	bufnr int
+
+	// help is the signature help shown in popup, with active the index of the
+	// signature shown. cycled is set if the signature was chosen via
+	// FunctionSignatureHelpCycle, in which case it is retained as the popup
+	// is updated.
+	help   *protocol.SignatureHelp
+	active int
+	cycled bool
+
+	// autocmds indicates whether the autocommands in signatureHelpAutoGroup
+	// are defined
+	autocmds bool
+}
+
+// signatureHelpAutoGroup is the augroup of the autocommands for signature
+// help while typing, which each cost a round trip to govim for every key
+// typed and every cursor movement in insert mode. They are therefore only
+// defined whilst SignatureHelpAuto is enabled.
+const signatureHelpAutoGroup = "govimSignatureHelpAuto"
+
+func (v *vimstate) autoSignatureHelpEnabled() bool {
+	return v.config.SignatureHelpAuto != nil && *v.config.SignatureHelpAuto
+}
+
+// updateSignatureHelpAutoCommands defines the autocommands in
+// signatureHelpAutoGroup if SignatureHelpAuto is enabled, and removes them
+// otherwise.
+func (v *vimstate) updateSignatureHelpAutoCommands() {
+	enabled := v.autoSignatureHelpEnabled()
+	if enabled == v.autoSignatureHelp.autocmds {
+		return
+	}
+	v.autoSignatureHelp.autocmds = enabled
+	if !enabled {
+		v.ChannelExf("autocmd! %v", signatureHelpAutoGroup)
+		v.autoSignatureHelp.pending = false
+		v.closeSignatureHelp()
+		return
+	}
+	v.ChannelExf("augroup %v | augroup END", signatureHelpAutoGroup)
+	v.DefineAutoCommand(signatureHelpAutoGroup, govim.Events{govim.EventInsertCharPre}, govim.Patterns{"*.go"}, false, v.signatureHelpCharPre, "v:char")
+	v.DefineAutoCommand(signatureHelpAutoGroup, govim.Events{govim.EventCursorMovedI}, govim.Patterns{"*.go"}, false, v.signatureHelpCursorMoved, exprAutocmdCursorPos)
+	v.DefineAutoCommand(signatureHelpAutoGroup, govim.Events{govim.EventInsertLeave}, govim.Patterns{"*.go"}, false, v.signatureHelpInsertLeave)
+}
+
+// signatureHelpCharPre marks signature help as pending if the character about
+// to be inserted starts or separates the arguments of a call.
+func (v *vimstate) signatureHelpCharPre(args ...json.RawMessage) error {
+	if !v.autoSignatureHelpEnabled() {
+		return nil
+	}
+	switch v.ParseString(args[0]) {
+	case "(", ",":
+		v.autoSignatureHelp.pending = true
+	}
+	return nil
+}
+
+// signatureHelpCursorMoved requests signature help for the cursor position if
+// signature help is pending or shown. The popup is then shown or updated
+// with the active parameter, or closed if the cursor is no longer within a
+// call.
+func (v *vimstate) signatureHelpCursorMoved(args ...json.RawMessage) error {
+	a := &v.autoSignatureHelp
+	if !a.pending && a.popup == 0 {
+		return nil
+	}
+	a.pending = false
+	var info struct {
+		BufNr int
+		Line  int
+		Col   int
+	}
+	v.Parse(args[0], &info)
+	b, ok := v.buffers[info.BufNr]
+	if !ok || (a.popup != 0 && a.bufnr != info.BufNr) {
+		v.closeSignatureHelp()
+		return nil
+	}
+	pos, err := types.PointFromVim(b, info.Line, info.Col)
+	if err != nil {
+		return fmt.Errorf("failed to get signature help position: %v", err)
+	}
+	v.cancelSignatureHelp()
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	params := &protocol.SignatureHelpParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: b.ToTextDocumentIdentifier(),
+			Position:     pos.ToPosition(),
+		},
+	}
+	v.tomb.Go(func() error {
+		res, err := v.goplsServer().SignatureHelp(ctx, params)
+		v.govimplugin.Schedule(func(govim.Govim) error {
+			// If the context is cancelled, the cursor has moved or insert
+			// mode has ended since the request was made
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+			cancel()
+			a.cancel = nil
+			if err != nil {
+				v.Logf("signature help failed: %v", err)
+				return nil
+			}
+			v.showSignatureHelp(b, res)
+			return nil
+		})
+		return nil
+	})
+	return nil
+}
+
+func (v *vimstate) signatureHelpInsertLeave(args ...json.RawMessage) error {
+	v.autoSignatureHelp.pending = false
+	v.closeSignatureHelp()
+	return nil
+}
+
+// signatureHelpCycle shows the next signature in the signature help popup,
+// if there is more than one.
+func (v *vimstate) signatureHelpCycle(args ...json.RawMessage) (interface{}, error) {
+	a := &v.autoSignatureHelp
+	if a.popup == 0 || a.help == nil || len(a.help.Signatures) < 2 {
+		return nil, nil
+	}
+	a.active = (a.active + 1) % len(a.help.Signatures)
+	a.cycled = true
+	v.renderSignatureHelp()
+	return nil, nil
+}
+
+func (v *vimstate) cancelSignatureHelp() {
+	if c := v.autoSignatureHelp.cancel; c != nil {
+		c()
+		v.autoSignatureHelp.cancel = nil
+	}
+}
+
+// showSignatureHelp shows res in the signature help popup for b, creating
+// the popup if needed. The popup is closed if res has no signatures, i.e.
+// the cursor is not within a call.
+func (v *vimstate) showSignatureHelp(b *types.Buffer, res *protocol.SignatureHelp) {
+	a := &v.autoSignatureHelp
+	if res == nil || len(res.Signatures) == 0 {
+		v.closeSignatureHelp()
+		return
+	}
+	active := int(res.ActiveSignature)
+	if a.cycled && a.help != nil && len(a.help.Signatures) == len(res.Signatures) {
+		active = a.active
+	} else {
+		a.cycled = false
+	}
+	if active >= len(res.Signatures) {
+		active = 0
+	}
+	a.help = res
+	a.active = active
+	a.bufnr = b.Num
+	v.renderSignatureHelp()
+}
+
+// renderSignatureHelp fills the signature help popup with the active
+// signature. The popup is created above the cursor if it is not shown, and
+// otherwise remains where it is as the cursor moves.
+func (v *vimstate) renderSignatureHelp() {
+	a := &v.autoSignatureHelp
+	sig := a.help.Signatures[a.active]
+	lines := signatureLines(sig, activeParameter(a.help, sig))
+	if n := len(a.help.Signatures); n > 1 {
+		text := fmt.Sprintf("(%d of %d)", a.active+1, n)
+		lines = append(lines, types.PopupLine{
+			Text:  text,
+			Props: []types.PopupProp{{Type: string(config.HighlightSignature), Col: 1, Len: len(text)}},
+		})
+	}
+	if a.popup != 0 && v.ParseInt(v.ChannelExprf("empty(popup_getpos(%v))", a.popup)) == 0 {
+		v.ChannelCall("popup_settext", a.popup, lines)
+		return
+	}
+	opts := make(map[string]interface{})
+	opts["pos"] = "botleft"
+	opts["padding"] = []int{0, 1, 0, 1}
+	opts["wrap"] = false
+	opts["line"] = "cursor-1"
+	opts["col"] = "cursor"
+	opts["close"] = "click"
+	a.popup = v.ParseInt(v.ChannelCall("popup_create", lines, opts))
+	v.ChannelCall("setbufvar", a.bufnr, "govim_signature_help", 1)
+}
+
+// closeSignatureHelp closes the signature help popup, if shown, and cancels
+// the SignatureHelp request in flight, if any.
+func (v *vimstate) closeSignatureHelp() {
+	v.cancelSignatureHelp()
+	a := &v.autoSignatureHelp
+	if a.popup != 0 {
+		v.ChannelCall("popup_close", a.popup)
+		v.ChannelCall("setbufvar", a.bufnr, "govim_signature_help", 0)
+	}
+	a.popup = 0
+	a.help = nil
+	a.cycled = false
+}
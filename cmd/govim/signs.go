@@ -19,6 +19,13 @@ var signName = map[int]config.Highlight{
 	types.SeverityPriority[types.SeverityHint]: config.HighlightSignHint,
 }
 
+// signText is the text of signs other than the default ">>"
+var signText = map[config.Highlight]string{
+	config.HighlightSignTestPass: "ok",
+	config.HighlightSignTestFail: "XX",
+	config.HighlightSignTestSkip: "--",
+}
+
 // defineDict is the representation of arguments used in vim's sign_define()
 type defineDict struct {
 	Text          string `json:"text"`   // One or two chars shown in the gutter
@@ -32,6 +39,9 @@ func (v *vimstate) signDefine() error {
 		config.HighlightSignWarn,
 		config.HighlightSignInfo,
 		config.HighlightSignHint,
+		config.HighlightSignTestPass,
+		config.HighlightSignTestFail,
+		config.HighlightSignTestSkip,
 	}
 	var useDefault []config.Highlight
 
@@ -52,8 +62,12 @@ func (v *vimstate) signDefine() error {
 	// Define default sign names
 	v.BatchStart()
 	for _, hi := range useDefault {
+		text, ok := signText[hi]
+		if !ok {
+			text = ">>"
+		}
 		arg := defineDict{
-			Text:          ">>",
+			Text:          text,
 			TextHighlight: string(hi),
 		}
 
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+// snippetPlaceholder is a tabstop ($1 or ${1}) or placeholder (${1:text}) of
+// a snippet, located by its byte offset and length in the expanded text of
+// the snippet.
+type snippetPlaceholder struct {
+	tabstop int
+	offset  int
+	length  int
+}
+
+// snippet is an inserted snippet, the placeholders of which are tracked as
+// text properties with consecutive IDs starting at types.SnippetTextPropID.
+type snippet struct {
+	bufnr int
+
+	// ids are the text property IDs of the placeholders in the order they are
+	// jumped between, the last one being the final tabstop ($0)
+	ids []int
+
+	// current is the index in ids of the placeholder last jumped to
+	current int
+}
+
+// parseSnippet parses s according to the LSP snippet grammar, returning the
+// expanded text and the placeholders in the order they appear in the text.
+// Only tabstops and placeholders are supported, anything else (e.g. a
+// variable) being treated as text.
+func parseSnippet(s string) (string, []snippetPlaceholder) {
+	p := &snippetParser{s: s}
+	p.parse(false)
+	return p.text.String(), p.placeholders
+}
+
+type snippetParser struct {
+	s            string
+	pos          int
+	text         strings.Builder
+	placeholders []snippetPlaceholder
+}
+
+// parse parses up to the end of the snippet or, if nested, up to the closing
+// brace of the placeholder being parsed.
+func (p *snippetParser) parse(nested bool) {
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.s) && strings.IndexByte(`$}\`, p.s[p.pos+1]) >= 0:
+			p.text.WriteByte(p.s[p.pos+1])
+			p.pos += 2
+		case c == '}' && nested:
+			p.pos++
+			return
+		case c == '$' && p.tabstop():
+		default:
+			p.text.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// tabstop parses the tabstop or placeholder at the current position, which
+// is a '$', reporting whether there was one.
+func (p *snippetParser) tabstop() bool {
+	i := p.pos + 1
+	braced := i < len(p.s) && p.s[i] == '{'
+	if braced {
+		i++
+	}
+	j := i
+	for j < len(p.s) && '0' <= p.s[j] && p.s[j] <= '9' {
+		j++
+	}
+	if j == i {
+		return false
+	}
+	n, err := strconv.Atoi(p.s[i:j])
+	if err != nil {
+		return false
+	}
+	ph := snippetPlaceholder{tabstop: n, offset: p.text.Len()}
+	switch {
+	case !braced:
+		p.pos = j
+	case j < len(p.s) && p.s[j] == '}':
+		p.pos = j + 1
+	case j < len(p.s) && p.s[j] == ':':
+		// The placeholder text may itself contain placeholders, which follow
+		// this one
+		idx := len(p.placeholders)
+		p.placeholders = append(p.placeholders, ph)
+		p.pos = j + 1
+		p.parse(true)
+		p.placeholders[idx].length = p.text.Len() - ph.offset
+		return true
+	default:
+		return false
+	}
+	p.placeholders = append(p.placeholders, ph)
+	return true
+}
+
+// snippetWord returns the word to insert for a completion item with snippet
+// text s while the item is selected in the completion menu: the expanded text
+// up to the first placeholder, the snippet being inserted as a whole by
+// expandSnippet once the item is chosen.
+func snippetWord(s string) string {
+	text, phs := parseSnippet(s)
+	if len(phs) > 0 {
+		text = text[:phs[0].offset]
+	}
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return text
+}
+
+// expandSnippet replaces word, just inserted before the cursor by completion,
+// with the expanded text of snippetText, tracking its placeholders such that
+// they can be jumped between.
+func (v *vimstate) expandSnippet(b *types.Buffer, word, snippetText string) error {
+	v.endSnippet()
+
+	var pos struct {
+		Line int `json:"line"`
+		Col  int `json:"col"`
+	}
+	v.Parse(v.ChannelExpr(`{"line": line("."), "col": col(".")}`), &pos)
+	line, err := b.Line(pos.Line)
+	if err != nil {
+		return fmt.Errorf("failed to get line %v: %v", pos.Line, err)
+	}
+	startCol := pos.Col - len(word)
+	if startCol < 1 || pos.Col-1 > len(line) || line[startCol-1:pos.Col-1] != word {
+		return fmt.Errorf("failed to find completed word %q before cursor at %v:%v", word, pos.Line, pos.Col)
+	}
+	start, err := types.PointFromVim(b, pos.Line, startCol)
+	if err != nil {
+		return fmt.Errorf("failed to derive start of snippet: %v", err)
+	}
+	end, err := types.PointFromVim(b, pos.Line, pos.Col)
+	if err != nil {
+		return fmt.Errorf("failed to derive end of snippet: %v", err)
+	}
+
+	text, phs := parseSnippet(snippetText)
+	hasFinal := false
+	for _, ph := range phs {
+		hasFinal = hasFinal || ph.tabstop == 0
+	}
+	if !hasFinal {
+		// The final tabstop defaults to the end of the snippet
+		phs = append(phs, snippetPlaceholder{offset: len(text)})
+	}
+
+	err = v.applyProtocolTextEdits(b, []protocol.TextEdit{{
+		Range:   protocol.Range{Start: start.ToPosition(), End: end.ToPosition()},
+		NewText: text,
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to insert snippet: %v", err)
+	}
+
+	// vimPos returns the position of offset in text once inserted
+	vimPos := func(offset int) (int, int) {
+		before := text[:offset]
+		nl := strings.Count(before, "\n")
+		if nl == 0 {
+			return start.Line(), start.Col() + offset
+		}
+		return start.Line() + nl, offset - strings.LastIndexByte(before, '\n')
+	}
+	v.BatchStart()
+	defer v.BatchCancelIfNotEnded()
+	for i, ph := range phs {
+		l, c := vimPos(ph.offset)
+		el, ec := vimPos(ph.offset + ph.length)
+		v.BatchAssertChannelCall(assertPropAdd, "prop_add", l, c, propAddDict{
+			Type:    string(config.HighlightSnippetPlaceholder),
+			ID:      types.SnippetTextPropID + i,
+			EndLine: el,
+			EndCol:  ec,
+			BufNr:   b.Num,
+		})
+	}
+	v.BatchChannelCall("setbufvar", b.Num, "govim_snippet", 1)
+	v.MustBatchEnd()
+
+	// Placeholders are jumped between in order of their tabstop, the final
+	// tabstop ($0) coming last
+	order := make([]int, len(phs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		ti, tj := phs[order[i]].tabstop, phs[order[j]].tabstop
+		if ti == 0 || tj == 0 {
+			return tj == 0 && ti != 0
+		}
+		return ti < tj
+	})
+	s := &snippet{
+		bufnr:   b.Num,
+		current: -1,
+	}
+	for _, i := range order {
+		s.ids = append(s.ids, types.SnippetTextPropID+i)
+	}
+	v.snippet = s
+	return nil
+}
+
+// jumpToFirstPlaceholder jumps to the first placeholder of the snippet just
+// inserted. Because we are in insert mode, the jump is made via typeahead as
+// if FunctionSnippetNext had been called via its mapping.
+func (v *vimstate) jumpToFirstPlaceholder() {
+	v.ChannelExf(`call feedkeys("\<C-\>\<C-n>:call %v%v()\<CR>", "in")`, PluginPrefix, config.FunctionSnippetNext)
+}
+
+func (v *vimstate) snippetNext(args ...json.RawMessage) (interface{}, error) {
+	return nil, v.snippetJump(1)
+}
+
+func (v *vimstate) snippetPrev(args ...json.RawMessage) (interface{}, error) {
+	return nil, v.snippetJump(-1)
+}
+
+// snippetJump jumps dir placeholders from the current one, skipping those
+// that no longer exist because their text has been deleted, e.g. replaced by
+// typing. Jumping to the final tabstop ends the snippet.
+func (v *vimstate) snippetJump(dir int) error {
+	s := v.snippet
+	if s == nil {
+		return nil
+	}
+	b, ok := v.buffers[s.bufnr]
+	if !ok || !b.Loaded {
+		v.endSnippet()
+		return nil
+	}
+	for i := s.current + dir; i >= 0 && i < len(s.ids); i += dir {
+		start, end, ok, err := v.findPlaceholder(b, s.ids[i])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		s.current = i
+		if err := v.selectPlaceholder(b, start, end); err != nil {
+			return err
+		}
+		if i == len(s.ids)-1 {
+			v.endSnippet()
+		}
+		return nil
+	}
+	if dir > 0 {
+		v.endSnippet()
+	}
+	return nil
+}
+
+// findPlaceholder returns the start and (exclusive) end of the placeholder
+// with text property ID id, reporting whether it still exists. The text
+// property of a placeholder that spans lines has a part on each line, the
+// last of which ends the placeholder.
+func (v *vimstate) findPlaceholder(b *types.Buffer, id int) (start, end types.Point, ok bool, err error) {
+	type propPart struct {
+		ID     int    `json:"id"`
+		Type   string `json:"type"`
+		Lnum   int    `json:"lnum"`
+		Col    int    `json:"col"`
+		Length int    `json:"length"`
+		End    int    `json:"end"`
+	}
+	var p propPart
+	v.Parse(v.ChannelCall("prop_find", struct {
+		Type  string `json:"type"`
+		ID    int    `json:"id"`
+		Both  int    `json:"both"`
+		BufNr int    `json:"bufnr"`
+		Lnum  int    `json:"lnum"`
+		Col   int    `json:"col"`
+	}{string(config.HighlightSnippetPlaceholder), id, 1, b.Num, 1, 1}, "f"), &p)
+	if p.Lnum == 0 {
+		return start, end, false, nil
+	}
+	start, err = types.PointFromVim(b, p.Lnum, p.Col)
+	if err != nil {
+		return start, end, false, fmt.Errorf("failed to derive start of placeholder: %v", err)
+	}
+	// prop_find only finds the first part, so look for the last part on the
+	// lines that follow
+	for lnum := p.Lnum + 1; p.End == 0; lnum++ {
+		if _, err := b.Line(lnum); err != nil {
+			return start, end, false, fmt.Errorf("failed to find end of placeholder starting at %v:%v: %v", start.Line(), start.Col(), err)
+		}
+		var parts []propPart
+		v.Parse(v.ChannelCall("prop_list", lnum, struct {
+			BufNr int `json:"bufnr"`
+		}{b.Num}), &parts)
+		for _, part := range parts {
+			if part.ID == id && part.Type == string(config.HighlightSnippetPlaceholder) {
+				p = part
+				p.Lnum = lnum
+			}
+		}
+	}
+	end, err = types.PointFromVim(b, p.Lnum, p.Col+p.Length)
+	if err != nil {
+		return start, end, false, fmt.Errorf("failed to derive end of placeholder: %v", err)
+	}
+	return start, end, true, nil
+}
+
+// selectPlaceholder selects the placeholder from start to end in select
+// mode, such that typing replaces it, or starts insert mode at the
+// placeholder if it is empty.
+func (v *vimstate) selectPlaceholder(b *types.Buffer, start, end types.Point) error {
+	if end.Offset() <= start.Offset() {
+		line, err := b.Line(start.Line())
+		if err != nil {
+			return fmt.Errorf("failed to get line %v: %v", start.Line(), err)
+		}
+		if start.Col() > len(line) {
+			v.ChannelExf("call cursor(%v, %v) | startinsert!", start.Line(), start.Col())
+		} else {
+			v.ChannelExf("call cursor(%v, %v) | startinsert", start.Line(), start.Col())
+		}
+		return nil
+	}
+	if err := v.selectRange(b, protocol.Range{Start: start.ToPosition(), End: end.ToPosition()}); err != nil {
+		return err
+	}
+	v.ChannelEx(`execute "normal! \<C-g>"`)
+	return nil
+}
+
+// endSnippet removes the placeholders of the current snippet, if any, after
+// which they can no longer be jumped between.
+func (v *vimstate) endSnippet() {
+	s := v.snippet
+	if s == nil {
+		return
+	}
+	v.snippet = nil
+	b, ok := v.buffers[s.bufnr]
+	if !ok || !b.Loaded {
+		return
+	}
+	v.BatchStart()
+	defer v.BatchCancelIfNotEnded()
+	v.BatchChannelCall("prop_remove", struct {
+		Type  string `json:"type"`
+		BufNr int    `json:"bufnr"`
+		All   int    `json:"all"`
+	}{string(config.HighlightSnippetPlaceholder), b.Num, 1})
+	v.BatchChannelCall("setbufvar", b.Num, "govim_snippet", 0)
+	v.MustBatchEnd()
+}
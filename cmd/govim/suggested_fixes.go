@@ -136,6 +136,10 @@ type suggestedFix struct {
 	msg     string
 	command *protocol.Command
 	edit    protocol.WorkspaceEdit
+
+	// resolve, if set, is a code action whose edit must be resolved via
+	// gopls before it can be applied.
+	resolve *protocol.CodeAction
 }
 
 func diagSuggestions(codeActions []protocol.CodeAction) []resolvableDiag {
@@ -161,7 +165,7 @@ func diagSuggestions(codeActions []protocol.CodeAction) []resolvableDiag {
 			if _, exist := resolvableDiags[k]; !exist {
 				resolvableDiags[k] = make([]suggestedFix, 0)
 			}
-			resolvableDiags[k] = append(resolvableDiags[k], suggestedFix{ca.Title, ca.Command, ca.Edit})
+			resolvableDiags[k] = append(resolvableDiags[k], suggestedFix{msg: ca.Title, command: ca.Command, edit: ca.Edit})
 		}
 	}
 
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/govim/govim"
 	"github.com/govim/govim/cmd/govim/config"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/kr/pretty"
 )
 
 // This file contains config that would otherwise be in the
@@ -29,6 +31,12 @@ const (
 	FunctionNonBatchCallInBatch config.Function = "NonBatchCallInBatch"
 	FunctionIgnoreErrorInBatch  config.Function = "IgnoreErrorInBatch"
 	FunctionShowMessagePopup    config.Function = config.InternalFunctionPrefix + "ShowMessagePopup"
+	FunctionKillGopls           config.Function = config.InternalFunctionPrefix + "KillGopls"
+	FunctionApplyWorkspaceEdit  config.Function = config.InternalFunctionPrefix + "ApplyWorkspaceEdit"
+	FunctionShowMessageRequest  config.Function = config.InternalFunctionPrefix + "ShowMessageRequest"
+	FunctionShowDocument        config.Function = config.InternalFunctionPrefix + "ShowDocument"
+	FunctionShowSignatureHelp   config.Function = config.InternalFunctionPrefix + "ShowSignatureHelp"
+	FunctionExpandSnippet       config.Function = config.InternalFunctionPrefix + "ExpandSnippet"
 )
 
 func (g *govimplugin) InitTestAPI() {
@@ -46,6 +54,12 @@ func (g *govimplugin) InitTestAPI() {
 	g.DefineFunction(string(FunctionAssertFailedBatch), []string{}, g.vimstate.assertFailedBatch)
 	g.DefineFunction(string(FunctionNonBatchCallInBatch), []string{}, g.vimstate.nonBatchCallInBatch)
 	g.DefineFunction(string(FunctionIgnoreErrorInBatch), []string{"fail"}, g.vimstate.ignoreErrorInBatch)
+	g.DefineFunction(string(FunctionKillGopls), []string{}, g.vimstate.killGopls)
+	g.DefineFunction(string(FunctionApplyWorkspaceEdit), []string{"params"}, g.vimstate.applyWorkspaceEditJSON)
+	g.DefineFunction(string(FunctionShowMessageRequest), []string{"params", "timeout"}, g.vimstate.showMessageRequestJSON)
+	g.DefineFunction(string(FunctionShowDocument), []string{"params"}, g.vimstate.showDocumentJSON)
+	g.DefineFunction(string(FunctionShowSignatureHelp), []string{"params"}, g.vimstate.showSignatureHelpJSON)
+	g.DefineFunction(string(FunctionExpandSnippet), []string{"word", "snippet"}, g.vimstate.expandSnippetText)
 }
 
 func (v *vimstate) hello(args ...json.RawMessage) (interface{}, error) {
@@ -153,3 +167,82 @@ func (v *vimstate) ignoreErrorInBatch(args ...json.RawMessage) (interface{}, err
 	res := v.MustBatchEnd()
 	return res, nil
 }
+
+// killGopls kills gopls, as if it had crashed.
+func (v *vimstate) killGopls(args ...json.RawMessage) (interface{}, error) {
+	return nil, v.gopls.Kill()
+}
+
+// applyWorkspaceEditJSON applies the workspace edit in the JSON encoded
+// parameters of a workspace/applyEdit request, as if sent by gopls.
+func (v *vimstate) applyWorkspaceEditJSON(args ...json.RawMessage) (interface{}, error) {
+	var params applyWorkspaceEditParams
+	if err := json.Unmarshal([]byte(v.ParseString(args[0])), &params); err != nil {
+		return nil, err
+	}
+	return v.applyWorkspaceEdit(&params)
+}
+
+// showMessageRequestJSON makes the ShowMessageRequest callback with the JSON
+// encoded parameters, as if called by gopls, waiting at most timeout
+// milliseconds for a response. The response is logged.
+func (v *vimstate) showMessageRequestJSON(args ...json.RawMessage) (interface{}, error) {
+	var params protocol.ShowMessageRequestParams
+	if err := json.Unmarshal([]byte(v.ParseString(args[0])), &params); err != nil {
+		return nil, err
+	}
+	timeout := time.Duration(v.ParseInt(args[1])) * time.Millisecond
+	v.tomb.Go(func() error {
+		_, err := v.showMessageRequestWithin(context.Background(), &params, timeout)
+		return err
+	})
+	return "", nil
+}
+
+// showDocumentJSON makes the ShowDocument callback with the JSON encoded
+// parameters, as if called by gopls. The response is logged.
+func (v *vimstate) showDocumentJSON(args ...json.RawMessage) (interface{}, error) {
+	var params protocol.ShowDocumentParams
+	if err := json.Unmarshal([]byte(v.ParseString(args[0])), &params); err != nil {
+		return nil, err
+	}
+	v.tomb.Go(func() error {
+		res, err := v.ShowDocument(context.Background(), &params)
+		if err == nil {
+			v.logGoplsClientf("ShowDocument response: %v", pretty.Sprint(res))
+		}
+		return err
+	})
+	return "", nil
+}
+
+// showSignatureHelpJSON shows the JSON encoded signature help for the
+// current buffer, as if returned by gopls. gopls itself only ever returns a
+// single signature.
+func (v *vimstate) showSignatureHelpJSON(args ...json.RawMessage) (interface{}, error) {
+	var help protocol.SignatureHelp
+	if err := json.Unmarshal([]byte(v.ParseString(args[0])), &help); err != nil {
+		return nil, err
+	}
+	b, _, err := v.bufCursorPos()
+	if err != nil {
+		return nil, err
+	}
+	v.showSignatureHelp(b, &help)
+	return "", nil
+}
+
+// expandSnippetText replaces word, which must be before the cursor, with the
+// snippet text, and jumps to its first placeholder, as if the snippet had
+// been chosen from the completion menu. It allows testing snippets gopls
+// does not return.
+func (v *vimstate) expandSnippetText(args ...json.RawMessage) (interface{}, error) {
+	b, _, err := v.bufCursorPos()
+	if err != nil {
+		return nil, err
+	}
+	if err := v.expandSnippet(b, v.ParseString(args[0]), v.ParseString(args[1])); err != nil {
+		return nil, err
+	}
+	return "", v.snippetJump(1)
+}
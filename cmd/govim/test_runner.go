@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/span"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+const (
+	testResultsBufName = "govim-test-results"
+	quickfixTestsTitle = "govim tests"
+
+	// testSignGroup is the sign group used for the signs placed on test
+	// functions. It is distinct from signGroup because diagnostic signs are
+	// replaced wholesale.
+	testSignGroup = "govim-tests"
+
+	testRerunFailed = "failed"
+
+	testActionRun  = "run"
+	testActionPass = "pass"
+	testActionFail = "fail"
+	testActionSkip = "skip"
+)
+
+// testSignName maps the outcome of a test to the sign placed on its function.
+var testSignName = map[string]config.Highlight{
+	testActionPass: config.HighlightSignTestPass,
+	testActionFail: config.HighlightSignTestFail,
+	testActionSkip: config.HighlightSignTestSkip,
+}
+
+// testOutputPos matches the file:line prefix that the testing package adds to
+// the output of t.Log, t.Error etc.
+var testOutputPos = regexp.MustCompile(`^(\S+\.go):(\d+): (.*)$`)
+
+// testBuildErrorPos matches the position of a build error reported by go test.
+var testBuildErrorPos = regexp.MustCompile(`^(\S+\.go):(\d+):(\d+): (.*)$`)
+
+// testEvent is an event emitted by go test -json, see "go doc cmd/test2json".
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// testFunc is the location of a test function.
+type testFunc struct {
+	filename string
+	line     int
+	endLine  int
+}
+
+// testRun is a run of go test started via CommandRunTests.
+type testRun struct {
+	dir string
+
+	// tests are the names of the top-level tests that are run, or nil if
+	// all tests of the package in dir are run
+	tests []string
+
+	// funcs are the test functions of the package in dir, keyed by name
+	funcs map[string]testFunc
+
+	// h is the hierarchy that shows the results
+	h *hierarchy
+
+	// results are the results of packages and tests in the order they were
+	// started, keyed by package and test name
+	results     map[testKey]*testResult
+	resultOrder []*testResult
+
+	// failed are the names of the top-level tests that failed, set once go
+	// test has finished
+	failed []string
+}
+
+type testKey struct {
+	pkg  string
+	test string
+}
+
+// testResult is the result of a package, or a test within a package, as
+// reported by go test -json.
+type testResult struct {
+	pkg     string
+	test    string
+	action  string
+	elapsed float64
+	output  []string
+	node    *hierarchyNode
+}
+
+// label returns the text shown for r in the hierarchy buffer. Subtests are
+// shown relative to their parent.
+func (r *testResult) label() string {
+	name := r.pkg
+	if r.test != "" {
+		name = r.test[strings.LastIndex(r.test, "/")+1:]
+	}
+	if r.action == testActionRun {
+		return "RUN  " + name
+	}
+	return fmt.Sprintf("%-4s %s (%.2fs)", strings.ToUpper(r.action), name, r.elapsed)
+}
+
+func (v *vimstate) runTests(flags govim.CommandFlags, args ...string) error {
+	b, _, err := v.bufCursorPos()
+	if err != nil {
+		return fmt.Errorf("failed to get cursor position: %v", err)
+	}
+	start, end, err := v.rangeFromFlags(b, flags)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(b.Name)
+	funcs, err := v.testFuncs(dir)
+	if err != nil {
+		return err
+	}
+	var tests []string
+	for name, f := range funcs {
+		if f.filename == b.Name && f.line <= end.Line() && f.endLine >= start.Line() {
+			tests = append(tests, name)
+		}
+	}
+	sort.Strings(tests)
+	return v.startTestRun(flags.Mods, dir, tests, funcs)
+}
+
+func (v *vimstate) rerunTests(flags govim.CommandFlags, args ...string) error {
+	last := v.testRun
+	if last == nil {
+		return fmt.Errorf("no tests have been run")
+	}
+	tests := last.tests
+	if len(args) == 1 {
+		if args[0] != testRerunFailed {
+			return fmt.Errorf("unknown argument %q; expected %q", args[0], testRerunFailed)
+		}
+		if len(last.failed) == 0 {
+			v.ChannelEx(`echom "No failed tests to rerun"`)
+			return nil
+		}
+		tests = last.failed
+	}
+	funcs, err := v.testFuncs(last.dir)
+	if err != nil {
+		return err
+	}
+	return v.startTestRun(flags.Mods, last.dir, tests, funcs)
+}
+
+// startTestRun runs tests, or all tests if tests is nil, of the package in dir
+// in the background. Any previous run is cancelled.
+func (v *vimstate) startTestRun(mods govim.CommModList, dir string, tests []string, funcs map[string]testFunc) error {
+	if v.cancelTestRun != nil {
+		v.cancelTestRun()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancelTestRun = cancel
+
+	cmdArgs := []string{"test", "-json"}
+	if len(tests) > 0 {
+		quoted := make([]string, len(tests))
+		for i, t := range tests {
+			quoted[i] = regexp.QuoteMeta(t)
+		}
+		cmdArgs = append(cmdArgs, "-run", "^("+strings.Join(quoted, "|")+")$")
+	}
+	cmdArgs = append(cmdArgs, ".")
+	cmd := exec.CommandContext(ctx, "go", cmdArgs...)
+	cmd.Dir = dir
+	cmd.Env = v.goCommandEnv()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+	v.Logf("running tests in %v: %v", dir, strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return fmt.Errorf("failed to run %v: %v", strings.Join(cmd.Args, " "), err)
+	}
+
+	r := &testRun{
+		dir:     dir,
+		tests:   tests,
+		funcs:   funcs,
+		results: make(map[testKey]*testResult),
+	}
+	v.testRun = r
+	v.ChannelCall("sign_unplace", testSignGroup)
+	r.h = v.showHierarchy(mods, testResultsBufName, nil)
+	// Stay in the window from which the tests were run
+	v.ChannelEx("wincmd p")
+
+	v.tomb.Go(func() error {
+		// Events are handled in batches, one per finished test, to avoid
+		// redrawing the results for every line of output
+		var events []testEvent
+		handle := func(evs []testEvent) {
+			v.govimplugin.Schedule(func(govim.Govim) error {
+				if v.testRun != r {
+					// Superseded by another run
+					return nil
+				}
+				v.handleTestEvents(r, evs)
+				return nil
+			})
+		}
+		sc := bufio.NewScanner(stdout)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			var e testEvent
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				v.Logf("failed to parse go test event %q: %v", sc.Text(), err)
+				continue
+			}
+			events = append(events, e)
+			switch e.Action {
+			case testActionPass, testActionFail, testActionSkip:
+				handle(events)
+				events = nil
+			}
+		}
+		if len(events) > 0 {
+			handle(events)
+		}
+		err := cmd.Wait()
+		v.govimplugin.Schedule(func(govim.Govim) error {
+			if v.testRun != r {
+				return nil
+			}
+			cancel()
+			if err := v.finishTestRun(r, stderr.String(), err); err != nil {
+				v.Logf("failed to handle test results: %v", err)
+			}
+			return nil
+		})
+		return nil
+	})
+	return nil
+}
+
+// testFuncs returns the test functions declared in the _test.go files of dir,
+// using the contents of open buffers over those on disk.
+func (v *vimstate) testFuncs(dir string) (map[string]testFunc, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %v: %v", dir, err)
+	}
+	fset := token.NewFileSet()
+	res := make(map[string]testFunc)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), "_test.go") {
+			continue
+		}
+		fn := filepath.Join(dir, e.Name())
+		var src interface{}
+		for _, b := range v.buffers {
+			if b.Name == fn {
+				src = b.Contents()
+				break
+			}
+		}
+		// Syntax errors are reported by go test, hence a partial AST is
+		// good enough
+		f, _ := parser.ParseFile(fset, fn, src, parser.SkipObjectResolution)
+		if f == nil {
+			continue
+		}
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || fd.Recv != nil || !isTestFuncName(fd.Name.Name) {
+				continue
+			}
+			res[fd.Name.Name] = testFunc{
+				filename: fn,
+				line:     fset.Position(fd.Pos()).Line,
+				endLine:  fset.Position(fd.End()).Line,
+			}
+		}
+	}
+	return res, nil
+}
+
+// isTestFuncName reports whether name is the name of a function that go test
+// runs by default, i.e. a test, fuzz test or example.
+func isTestFuncName(name string) bool {
+	for _, prefix := range []string{"Test", "Fuzz", "Example"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if len(name) == len(prefix) {
+			return true
+		}
+		r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+		return !unicode.IsLower(r)
+	}
+	return false
+}
+
+// testResultFor returns the result for test in pkg, creating it and the
+// results of its parents as required.
+func (v *vimstate) testResultFor(r *testRun, pkg, test string) *testResult {
+	key := testKey{pkg: pkg, test: test}
+	if res, ok := r.results[key]; ok {
+		return res
+	}
+	res := &testResult{
+		pkg:    pkg,
+		test:   test,
+		action: testActionRun,
+		node: &hierarchyNode{
+			loaded:   true,
+			expanded: true,
+		},
+	}
+	if test == "" {
+		r.h.roots = append(r.h.roots, res.node)
+	} else {
+		parent := ""
+		if i := strings.LastIndex(test, "/"); i != -1 {
+			parent = test[:i]
+		}
+		p := v.testResultFor(r, pkg, parent)
+		p.node.children = append(p.node.children, res.node)
+		if f, ok := r.funcs[test]; ok {
+			res.node.loc = protocol.Location{
+				URI: protocol.DocumentURI(span.URIFromPath(f.filename)),
+				Range: protocol.Range{
+					Start: protocol.Position{Line: uint32(f.line - 1)},
+					End:   protocol.Position{Line: uint32(f.line - 1)},
+				},
+			}
+		}
+	}
+	res.node.name = res.label()
+	r.results[key] = res
+	r.resultOrder = append(r.resultOrder, res)
+	return res
+}
+
+// handleTestEvents updates the results of r with events and redraws them.
+func (v *vimstate) handleTestEvents(r *testRun, events []testEvent) {
+	for _, e := range events {
+		res := v.testResultFor(r, e.Package, e.Test)
+		switch e.Action {
+		case "output":
+			res.output = append(res.output, strings.TrimSuffix(e.Output, "\n"))
+		case testActionRun, testActionPass, testActionFail, testActionSkip:
+			res.action = e.Action
+			res.elapsed = e.Elapsed
+		}
+		res.node.name = res.label()
+	}
+	v.redrawHierarchy(r.h)
+}
+
+// finishTestRun handles the completion of the go test process of r, which
+// exited with err. Failing tests are expanded to show their output and are
+// added to the quickfix list along with any build errors in stderr, and test
+// functions are marked with signs.
+func (v *vimstate) finishTestRun(r *testRun, stderr string, err error) error {
+	// must be non-nil
+	fixes := []quickfixEntry{}
+	for _, l := range strings.Split(stderr, "\n") {
+		m := testBuildErrorPos.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		fixes = append(fixes, quickfixEntry{
+			Filename: v.relativeFilename(filepath.Join(r.dir, m[1])),
+			Lnum:     line,
+			Col:      col,
+			Text:     m[4],
+		})
+	}
+	if err != nil && len(r.resultOrder) == 0 && len(fixes) == 0 {
+		err = fmt.Errorf("go test failed: %v\n%s", err, strings.TrimSpace(stderr))
+		v.showMessage("ErrorMsg", err.Error())
+		return err
+	}
+
+	var passed, failed, skipped int
+	for _, res := range r.resultOrder {
+		if res.test == "" {
+			continue
+		}
+		switch res.action {
+		case testActionPass:
+			passed++
+		case testActionSkip:
+			skipped++
+		case testActionFail:
+			failed++
+		}
+		if res.action != testActionFail {
+			res.node.expanded = false
+			continue
+		}
+		if !strings.Contains(res.test, "/") {
+			r.failed = append(r.failed, res.test)
+		}
+		for _, l := range res.output {
+			l = strings.TrimSpace(l)
+			if l == "" || strings.HasPrefix(l, "=== ") || strings.HasPrefix(l, "--- ") {
+				continue
+			}
+			n := &hierarchyNode{name: l, loaded: true}
+			if m := testOutputPos.FindStringSubmatch(l); m != nil {
+				line, _ := strconv.Atoi(m[2])
+				fn := filepath.Join(r.dir, m[1])
+				// The position is shown as part of the location
+				n.name = m[3]
+				n.loc = protocol.Location{
+					URI: protocol.DocumentURI(span.URIFromPath(fn)),
+					Range: protocol.Range{
+						Start: protocol.Position{Line: uint32(line - 1)},
+						End:   protocol.Position{Line: uint32(line - 1)},
+					},
+				}
+				fixes = append(fixes, quickfixEntry{
+					Filename: v.relativeFilename(fn),
+					Lnum:     line,
+					Col:      1,
+					Text:     res.test + ": " + m[3],
+				})
+			}
+			res.node.children = append(res.node.children, n)
+		}
+	}
+	v.redrawHierarchy(r.h)
+
+	var qflist qflistProps
+	v.Parse(v.ChannelExpr(`getqflist({"title":1})`), &qflist)
+	if len(fixes) > 0 || qflist.Title == quickfixTestsTitle {
+		v.BatchStart()
+		v.BatchChannelCall("setqflist", fixes, "r")
+		v.BatchChannelCall("setqflist", []quickfixEntry{}, "r", qflistProps{Title: quickfixTestsTitle})
+		v.MustBatchEnd()
+	}
+
+	var placeList []placeDict
+	for _, b := range v.buffers {
+		placeList = append(placeList, v.testSigns(b)...)
+	}
+	if len(placeList) > 0 {
+		v.ChannelCall("sign_placelist", placeList)
+	}
+
+	v.ChannelExf("echom %q", fmt.Sprintf("Tests: %d passed, %d failed, %d skipped", passed, failed, skipped))
+	return nil
+}
+
+// testSigns returns the signs for the test functions in b that were run by
+// the last run of CommandRunTests.
+func (v *vimstate) testSigns(b *types.Buffer) []placeDict {
+	r := v.testRun
+	if r == nil || !b.Loaded || filepath.Dir(b.Name) != r.dir {
+		return nil
+	}
+	var res []placeDict
+	for _, tr := range r.resultOrder {
+		if tr.test == "" || strings.Contains(tr.test, "/") {
+			continue
+		}
+		f, ok := r.funcs[tr.test]
+		name, signed := testSignName[tr.action]
+		if !ok || !signed || f.filename != b.Name {
+			continue
+		}
+		res = append(res, placeDict{
+			Buffer: b.Num,
+			Group:  testSignGroup,
+			Lnum:   f.line,
+			Name:   string(name),
+		})
+	}
+	return res
+}
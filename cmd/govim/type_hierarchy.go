@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/span"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+const typeHierarchyBufName = "govim-type-hierarchy"
+
+func (v *vimstate) typeHierarchy(flags govim.CommandFlags, args ...string) error {
+	b, pos, err := v.bufCursorPos()
+	if err != nil {
+		return fmt.Errorf("failed to get current position: %v", err)
+	}
+	params := &protocol.TypeHierarchyPrepareParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{
+				URI: protocol.DocumentURI(b.URI()),
+			},
+			Position: pos.ToPosition(),
+		},
+	}
+	var items []protocol.TypeHierarchyItem
+	if v.typeHierarchySupported() {
+		items, err = v.server.PrepareTypeHierarchy(context.Background(), params)
+		if err != nil {
+			return fmt.Errorf("call to gopls.PrepareTypeHierarchy failed: %v", err)
+		}
+	} else {
+		items, err = v.prepareImplementationHierarchy(params.TextDocumentPositionParams)
+		if err != nil {
+			return err
+		}
+	}
+	if len(items) == 0 {
+		v.ChannelEx(`echom "No type at cursor position"`)
+		return nil
+	}
+	var roots []*hierarchyNode
+	for _, item := range items {
+		item := item
+		// A root is the only node that can be expanded in both directions. Its
+		// children group its supertypes and subtypes respectively.
+		roots = append(roots, &hierarchyNode{
+			name: item.Name,
+			loc:  protocol.Location{URI: item.URI, Range: item.SelectionRange},
+			expand: func() ([]*hierarchyNode, error) {
+				return []*hierarchyNode{
+					{
+						name: "supertypes",
+						expand: func() ([]*hierarchyNode, error) {
+							return v.supertypes(item)
+						},
+					},
+					{
+						name: "subtypes",
+						expand: func() ([]*hierarchyNode, error) {
+							return v.subtypes(item)
+						},
+					},
+				}, nil
+			},
+		})
+	}
+	return v.openHierarchy(flags.Mods, typeHierarchyBufName, roots)
+}
+
+// typeHierarchyNode returns a hierarchy node for item whose children, either
+// its supertypes or subtypes, are given by expand.
+func (v *vimstate) typeHierarchyNode(item protocol.TypeHierarchyItem, expand func(protocol.TypeHierarchyItem) ([]*hierarchyNode, error)) *hierarchyNode {
+	return &hierarchyNode{
+		name: item.Name,
+		loc:  protocol.Location{URI: item.URI, Range: item.SelectionRange},
+		expand: func() ([]*hierarchyNode, error) {
+			return expand(item)
+		},
+	}
+}
+
+func (v *vimstate) supertypes(item protocol.TypeHierarchyItem) ([]*hierarchyNode, error) {
+	var items []protocol.TypeHierarchyItem
+	var err error
+	if v.typeHierarchySupported() {
+		params := &protocol.TypeHierarchySupertypesParams{
+			Item: item,
+		}
+		items, err = v.server.Supertypes(context.Background(), params)
+		if err != nil {
+			return nil, fmt.Errorf("call to gopls.Supertypes failed: %v", err)
+		}
+	} else if item.Kind != protocol.Interface {
+		items, err = v.implementationTypes(item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var res []*hierarchyNode
+	for _, i := range items {
+		res = append(res, v.typeHierarchyNode(i, v.supertypes))
+	}
+	return res, nil
+}
+
+func (v *vimstate) subtypes(item protocol.TypeHierarchyItem) ([]*hierarchyNode, error) {
+	var items []protocol.TypeHierarchyItem
+	var err error
+	if v.typeHierarchySupported() {
+		params := &protocol.TypeHierarchySubtypesParams{
+			Item: item,
+		}
+		items, err = v.server.Subtypes(context.Background(), params)
+		if err != nil {
+			return nil, fmt.Errorf("call to gopls.Subtypes failed: %v", err)
+		}
+	} else if item.Kind == protocol.Interface {
+		items, err = v.implementationTypes(item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var res []*hierarchyNode
+	for _, i := range items {
+		res = append(res, v.typeHierarchyNode(i, v.subtypes))
+	}
+	return res, nil
+}
+
+// typeHierarchySupported reports whether gopls implements the type hierarchy
+// requests. Older versions of gopls do not, in which case the hierarchy is
+// derived from textDocument/implementation instead. That only relates
+// interfaces to the concrete types that implement them, i.e. interfaces have
+// no supertypes and concrete types no subtypes.
+func (v *vimstate) typeHierarchySupported() bool {
+	return providerSupported(v.goplsCapabilities.TypeHierarchyProvider)
+}
+
+// prepareImplementationHierarchy returns the type declared at the definition
+// of the identifier at pos as a type hierarchy item, for when gopls does not
+// implement textDocument/prepareTypeHierarchy.
+func (v *vimstate) prepareImplementationHierarchy(pos protocol.TextDocumentPositionParams) ([]protocol.TypeHierarchyItem, error) {
+	params := &protocol.DefinitionParams{
+		TextDocumentPositionParams: pos,
+	}
+	locs, err := v.server.Definition(context.Background(), params)
+	if err != nil {
+		return nil, fmt.Errorf("call to gopls.Definition failed: %v", err)
+	}
+	return v.typeHierarchyItems(locs)
+}
+
+// implementationTypes returns the interfaces implemented by the concrete type
+// item, or the concrete types that implement the interface item.
+func (v *vimstate) implementationTypes(item protocol.TypeHierarchyItem) ([]protocol.TypeHierarchyItem, error) {
+	params := &protocol.ImplementationParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: item.URI},
+			Position:     item.SelectionRange.Start,
+		},
+	}
+	locs, err := v.server.Implementation(context.Background(), params)
+	if err != nil {
+		return nil, fmt.Errorf("call to gopls.Implementation failed: %v", err)
+	}
+	return v.typeHierarchyItems(locs)
+}
+
+// typeHierarchyItems returns type hierarchy items for those of locs that
+// declare a type, see typeHierarchyItemAt.
+func (v *vimstate) typeHierarchyItems(locs []protocol.Location) ([]protocol.TypeHierarchyItem, error) {
+	var res []protocol.TypeHierarchyItem
+	for _, loc := range locs {
+		item, ok, err := v.typeHierarchyItemAt(loc)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res = append(res, item)
+		}
+	}
+	return res, nil
+}
+
+// typeHierarchyItemAt returns a type hierarchy item for the type whose name is
+// declared at loc, with the kind determined from the declaration of the type
+// as reported by hover. ok is false if loc does not declare a type.
+func (v *vimstate) typeHierarchyItemAt(loc protocol.Location) (item protocol.TypeHierarchyItem, ok bool, err error) {
+	b, err := v.uriBuffer(span.URI(loc.URI))
+	if err != nil {
+		return item, false, err
+	}
+	start, err := types.PointFromPosition(b, loc.Range.Start)
+	if err != nil {
+		return item, false, fmt.Errorf("failed to resolve start of %v: %v", loc, err)
+	}
+	end, err := types.PointFromPosition(b, loc.Range.End)
+	if err != nil {
+		return item, false, fmt.Errorf("failed to resolve end of %v: %v", loc, err)
+	}
+	name := string(b.Contents()[start.Offset():end.Offset()])
+	hov, err := v.hoverMsgAt(start, protocol.TextDocumentIdentifier{URI: loc.URI})
+	if err != nil {
+		return item, false, err
+	}
+	decl := strings.SplitN(hov, "\n", 2)[0]
+	rest := strings.TrimPrefix(decl, "type "+name)
+	if rest == decl {
+		return item, false, nil
+	}
+	if strings.HasPrefix(rest, "[") {
+		// Skip type parameters
+		if i := strings.Index(rest, "]"); i >= 0 {
+			rest = rest[i+1:]
+		}
+	}
+	kind := protocol.Class
+	if strings.HasPrefix(strings.TrimSpace(rest), "interface") {
+		kind = protocol.Interface
+	}
+	item = protocol.TypeHierarchyItem{
+		Name:           name,
+		Kind:           kind,
+		URI:            loc.URI,
+		Range:          loc.Range,
+		SelectionRange: loc.Range,
+	}
+	return item, true, nil
+}
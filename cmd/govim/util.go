@@ -19,6 +19,8 @@ import (
 
 const (
 	exprAutocmdCurrBufInfo = `{"Num": eval(expand('<abuf>')), "Name": fnamemodify(bufname(eval(expand('<abuf>'))),':p'), "Contents": join(getbufline(eval(expand('<abuf>')), 0, "$"), "\n")."\n", "Loaded": bufloaded(eval(expand('<abuf>')))}`
+	exprAutocmdCursorPos   = `{"BufNr": eval(expand('<abuf>')), "Line": line("."), "Col": col(".")}`
+	exprAsyncCompleteInfo  = `{"BufNr": eval(expand('<abuf>')), "Line": line("."), "Col": col("."), "Text": getline("."), "ChangedTick": b:changedtick, "Selected": pumvisible() ? complete_info(["selected"]).selected : -1}`
 )
 
 // currentBufferInfo is a helper function to unmarshal autocmd current
@@ -97,22 +99,40 @@ func (v *vimstate) parseCursorPos(r json.RawMessage) (types.CursorPosition, erro
 	return cp, nil
 }
 
-func (v *vimstate) locationToQuickfix(loc protocol.Location, rel bool) (qf quickfixEntry, err error) {
-	var buf *types.Buffer
+// providerSupported reports whether p, a provider in the capabilities of
+// gopls, indicates that gopls supports the corresponding request.
+func providerSupported(p interface{}) bool {
+	switch p := p.(type) {
+	case nil:
+		return false
+	case bool:
+		return p
+	}
+	return true
+}
+
+// uriBuffer returns the loaded buffer for uri if there is one, and otherwise a
+// temporary buffer with the contents of the file on disk.
+func (v *vimstate) uriBuffer(uri span.URI) (*types.Buffer, error) {
 	for _, b := range v.buffers {
-		if b.Loaded && b.URI() == span.URI(loc.URI) {
-			buf = b
+		if b.Loaded && b.URI() == uri {
+			return b, nil
 		}
 	}
-	fn := span.URI(loc.URI).Filename()
-	if buf == nil {
-		byts, err := os.ReadFile(fn)
-		if err != nil {
-			return qf, fmt.Errorf("failed to read contents of %v: %v", fn, err)
-		}
-		// create a temp buffer
-		buf = types.NewBuffer(-1, fn, byts, false)
+	fn := uri.Filename()
+	byts, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contents of %v: %v", fn, err)
+	}
+	return types.NewBuffer(-1, fn, byts, false), nil
+}
+
+func (v *vimstate) locationToQuickfix(loc protocol.Location, rel bool) (qf quickfixEntry, err error) {
+	buf, err := v.uriBuffer(span.URI(loc.URI))
+	if err != nil {
+		return qf, err
 	}
+	fn := span.URI(loc.URI).Filename()
 	// make fn relative for reporting purposes
 	if rel {
 		fn, err = filepath.Rel(v.workingDirectory, fn)
@@ -259,3 +279,17 @@ func (v *vimstate) rangeFromFlags(b *types.Buffer, flags govim.CommandFlags) (st
 	}
 	return start, end, nil
 }
+
+// goCommandEnv returns the environment for go commands run on behalf of the
+// user, e.g. go test. This is the environment of gopls, i.e. including
+// config.Config.GoplsEnv, such that the results are consistent with those from
+// gopls.
+func (v *vimstate) goCommandEnv() []string {
+	env := append([]string(nil), v.goplsEnv...)
+	if v.config.GoplsEnv != nil {
+		for k, val := range *v.config.GoplsEnv {
+			env = append(env, k+"="+val)
+		}
+	}
+	return env
+}
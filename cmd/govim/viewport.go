@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+// visibleLines is a range of lines of a buffer that are visible in one or more
+// windows.
+type visibleLines struct {
+	buf *types.Buffer
+
+	// version is the version of buf at the point the range was calculated
+	version int32
+
+	// start and end are the first and last (1-indexed) visible lines
+	start, end int
+}
+
+// toRange returns the LSP range that covers l.
+func (l visibleLines) toRange() protocol.Range {
+	return protocol.Range{
+		Start: protocol.Position{Line: uint32(l.start - 1)},
+		End:   protocol.Position{Line: uint32(l.end)},
+	}
+}
+
+// visibleBufferLines returns the lines of buffers tracked by govim that are
+// visible in windows of the current tab page.
+func (v *vimstate) visibleBufferLines() []visibleLines {
+	vp := v.Viewport()
+	var res []visibleLines
+	seen := make(map[int]int)
+	for _, w := range vp.Windows {
+		if w.TabNr != vp.Current.TabNr {
+			continue
+		}
+		b, ok := v.buffers[w.BufNr]
+		if !ok || !b.Loaded {
+			continue
+		}
+		i, ok := seen[b.Num]
+		if !ok {
+			seen[b.Num] = len(res)
+			res = append(res, visibleLines{buf: b, version: b.Version, start: w.TopLine, end: w.BotLine})
+			continue
+		}
+		// The buffer is visible in more than one window. Rather than tracking
+		// each range separately we use a range that covers them all.
+		if w.TopLine < res[i].start {
+			res[i].start = w.TopLine
+		}
+		if w.BotLine > res[i].end {
+			res[i].end = w.BotLine
+		}
+	}
+	return res
+}
+
+// bufWinEnter is called when a window starts showing a buffer, which changes
+// the visible lines of buffers and might need the folds of the buffer to be
+// applied in the window.
+func (v *vimstate) bufWinEnter(args ...json.RawMessage) error {
+	if b, ok := v.buffers[v.ParseInt(args[0])]; ok {
+		v.showFolds(b)
+	}
+	return v.viewportChanged()
+}
+
+// viewportChanged is called when the visible lines of buffers might have
+// changed, e.g. when a window is scrolled.
+func (v *vimstate) viewportChanged(args ...json.RawMessage) error {
+	if err := v.updateInlayHints(); err != nil {
+		return err
+	}
+	return v.updateSemanticTokens()
+}
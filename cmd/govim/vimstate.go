@@ -9,6 +9,7 @@ import (
 
 	"github.com/govim/govim/cmd/govim/config"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/span"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/command"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
 	"github.com/govim/govim/cmd/govim/internal/types"
 	"github.com/govim/govim/cmd/govim/internal/vimconfig"
@@ -34,6 +35,24 @@ type vimstate struct {
 	// state here
 	lastCompleteResults *protocol.CompletionList
 
+	// asyncCompletion is the state of completion as you type, see
+	// CompletionAsync
+	asyncCompletion asyncCompletion
+
+	// completionDocs caches the info popup lines of the items of the last
+	// completion results, keyed by completionDocsKey, see
+	// CompletionLazyDocumentation. cancelCompletionDocsFn cancels the
+	// request for the documentation of the selected item in flight, if any.
+	// completeChangedAutocmds indicates whether the autocommand in
+	// completeChangedGroup is defined.
+	completionDocs          map[string][]string
+	cancelCompletionDocsFn  context.CancelFunc
+	completeChangedAutocmds bool
+
+	// autoSignatureHelp is the state of signature help while typing the
+	// arguments of a call, see SignatureHelpAuto
+	autoSignatureHelp autoSignatureHelp
+
 	defaultConfig config.Config
 	config        config.Config
 	configLock    sync.Mutex
@@ -58,10 +77,54 @@ type vimstate struct {
 	// codeAction call.
 	suggestedFixesPopups map[int][]suggestedFix
 
-	// working directory (when govim was started)
-	// TODO: handle changes to current working directory during runtime
+	// messageRequestPopups are the popups shown for ShowMessageRequest calls
+	// from gopls, keyed by popup ID. The index of the selected action is sent
+	// to the channel of a popup when it is closed.
+	messageRequestPopups map[int]chan<- int
+
+	// workingDirectory is Vim's global current directory, which is updated
+	// by dirChanged
 	workingDirectory string
 
+	// watchedFiles are the file system watchers registered by gopls via
+	// workspace/didChangeWatchedFiles, keyed by registration ID. It is nil
+	// until gopls first registers watchers.
+	watchedFiles map[string][]fileSystemWatcher
+
+	// pendingFileEvents are the file system events yet to be sent to gopls
+	pendingFileEvents []protocol.FileEvent
+
+	// fileEventsFlushScheduled indicates that pendingFileEvents are due to be
+	// sent to gopls
+	fileEventsFlushScheduled bool
+
+	// workspaceRoots caches the directory of the go.work or go.mod file that
+	// applies to a directory containing buffers, or "" if there is none.
+	workspaceRoots map[string]string
+
+	// vulns are the vulnerabilities found by the last run of govulncheck,
+	// which are used to annotate go.mod buffers
+	vulns []command.Vuln
+
+	// cancelVulncheck cancels the ongoing run of govulncheck, if any
+	cancelVulncheck context.CancelFunc
+
+	// testRun is the last run of tests via CommandRunTests
+	testRun *testRun
+
+	// cancelTestRun cancels the ongoing run of tests, if any
+	cancelTestRun context.CancelFunc
+
+	// coverage is the test coverage shown via CommandCoverage, if any
+	coverage *coverage
+
+	// cancelCoverage cancels the ongoing run of tests for coverage, if any
+	cancelCoverage context.CancelFunc
+
+	// snippet is the snippet most recently inserted by completion, as long as
+	// its placeholders can be jumped between
+	snippet *snippet
+
 	// currentReferences is the range of each LSP documentHighlights under the cursor
 	// It is used to avoid updating the text property when the cursor is moved within the
 	// existing highlights.
@@ -94,6 +157,33 @@ type vimstate struct {
 	// A nil value is used to indicate that there is no ongoing vimgrep.
 	// When vimgrep is done, these buffers are added to govim.
 	vimgrepPendingBufs map[int]*types.Buffer
+
+	// hierarchies are the hierarchy buffers, e.g. that created by
+	// CommandCallHierarchy, keyed by buffer number.
+	hierarchies map[int]*hierarchy
+
+	// semanticTokensLegend is the legend used to decode semantic tokens, as
+	// registered by gopls. It is nil if gopls has not registered support for
+	// semantic tokens.
+	semanticTokensLegend *protocol.SemanticTokensLegend
+
+	// semanticTokens are the most recent full set of semantic tokens for each
+	// buffer, keyed by buffer number. They are used to request deltas when a
+	// buffer changes.
+	semanticTokens map[int]*semanticTokens
+
+	// semanticTokensNoDelta indicates that gopls failed to provide semantic
+	// token deltas, in which case tokens are requested for the visible range
+	// of a buffer instead.
+	semanticTokensNoDelta bool
+
+	// folds are the fold levels most recently computed for each buffer,
+	// keyed by buffer number.
+	folds map[int]*bufferFolds
+
+	// selectionStacks are the selections made by expanding the visual
+	// selection, keyed by window ID.
+	selectionStacks map[int]*selectionStack
 }
 
 // quickfixCanDiagnostics returns true if the quickfix can be populated with
@@ -167,12 +257,35 @@ func (v *vimstate) setConfig(args ...json.RawMessage) (interface{}, error) {
 		}
 	}
 
+	v.updateAsyncCompleteAutoCommands()
+	v.updateCompleteChangedAutoCommands()
+	v.updateSignatureHelpAutoCommands()
+
+	if !vimconfig.EqualBool(v.config.Folding, preConfig.Folding) {
+		if v.config.Folding == nil || !*v.config.Folding {
+			v.removeFolds()
+		} else {
+			for _, b := range v.buffers {
+				v.updateFolds(b)
+			}
+		}
+	}
+
 	// v.server will be nil when we are Init()-ing govim. The init process
 	// triggers a "manual" call of govim#config#Set() and hence this function
 	// gets called before we have even started gopls.
 	var err error
 	if v.server != nil {
 		err = v.server.DidChangeConfiguration(context.Background(), &protocol.DidChangeConfigurationParams{})
+		// The set of enabled inlay hints might have changed, something that
+		// gopls needs to know about before we request hints.
+		if v.inlayHintsEnabled() {
+			if err := v.updateInlayHints(); err != nil {
+				return nil, fmt.Errorf("failed to update inlay hints: %v", err)
+			}
+		} else {
+			v.removeInlayHints()
+		}
 	}
 
 	return nil, err
@@ -184,6 +297,10 @@ func (v *vimstate) popupSelection(args ...json.RawMessage) (interface{}, error)
 	v.Parse(args[0], &popupID)
 	v.Parse(args[1], &selection)
 
+	if v.messageRequestSelection(popupID, selection) {
+		return nil, nil
+	}
+
 	var fixes []suggestedFix
 	var ok bool
 	if fixes, ok = v.suggestedFixesPopups[popupID]; !ok {
@@ -201,12 +318,25 @@ func (v *vimstate) popupSelection(args ...json.RawMessage) (interface{}, error)
 		return nil, nil
 	}
 
-	fix := fixes[selection-1]
+	return nil, v.applySuggestedFix(fixes[selection-1])
+}
+
+// applySuggestedFix applies the edit of fix, resolving it first if needed, and
+// then executes its command.
+func (v *vimstate) applySuggestedFix(fix suggestedFix) error {
+	if fix.resolve != nil {
+		ca, err := v.server.ResolveCodeAction(context.Background(), fix.resolve)
+		if err != nil {
+			return fmt.Errorf("failed to resolve code action %q: %v", fix.msg, err)
+		}
+		fix.edit = ca.Edit
+		fix.command = ca.Command
+	}
 
 	// Edits should be applied before any Command according to LSP 3.16.
 	if len(fix.edit.DocumentChanges) > 0 {
 		if err := v.applyMultiBufTextedits(nil, fix.edit.DocumentChanges); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -219,7 +349,7 @@ func (v *vimstate) popupSelection(args ...json.RawMessage) (interface{}, error)
 
 		var ecErr error
 		v.tomb.Go(func() error {
-			_, ecErr = v.server.ExecuteCommand(context.Background(),
+			_, ecErr = v.goplsServer().ExecuteCommand(context.Background(),
 				&protocol.ExecuteCommandParams{
 					Command:   fix.command.Command,
 					Arguments: fix.command.Arguments,
@@ -236,16 +366,16 @@ func (v *vimstate) popupSelection(args ...json.RawMessage) (interface{}, error)
 			select {
 			case <-done:
 				if ecErr != nil {
-					return nil, fmt.Errorf("executeCommand failed: %v", ecErr)
+					return fmt.Errorf("executeCommand failed: %v", ecErr)
 				}
-				return nil, nil
+				return nil
 			case c := <-editsCh:
 				res, err := v.applyWorkspaceEdit(c.params)
 				c.responseCh <- applyEditResponse{res, err}
 			}
 		}
 	}
-	return nil, nil
+	return nil
 }
 
 func (v *vimstate) progressClosed(args ...json.RawMessage) (interface{}, error) {
@@ -262,6 +392,14 @@ func (v *vimstate) progressClosed(args ...json.RawMessage) (interface{}, error)
 		}
 	}
 
+	// Closing the popup of ongoing work, as opposed to the popup closing
+	// itself once the work has ended, cancels the work
+	if popup, ok := v.progressPopups[toDelete]; ok && popup != nil && popup.Cancellable && !popup.Ended {
+		if err := v.cancelProgressToken(toDelete); err != nil {
+			v.Logf("%v", err)
+		}
+	}
+
 	delete(v.progressPopups, toDelete)
 	v.rearrangeProgressPopups()
 
@@ -291,7 +429,7 @@ func (v *vimstate) setUserBusy(args ...json.RawMessage) (interface{}, error) {
 // applyEditCall represents a single LSP ApplyEdit call including a channel used
 // to pass a response back.
 type applyEditCall struct {
-	params     *protocol.ApplyWorkspaceEditParams
+	params     *applyWorkspaceEditParams
 	responseCh chan applyEditResponse
 }
 
@@ -301,16 +439,38 @@ type applyEditResponse struct {
 	err error
 }
 
-func (v *vimstate) applyWorkspaceEdit(params *protocol.ApplyWorkspaceEditParams) (*protocol.ApplyWorkspaceEditResult, error) {
+func (v *vimstate) applyWorkspaceEdit(params *applyWorkspaceEditParams) (*protocol.ApplyWorkspaceEditResult, error) {
 	res := &protocol.ApplyWorkspaceEditResult{Applied: true}
 
 	edits := make(map[*types.Buffer][]protocol.TextEdit)
+	// applyEdits applies the edits collected so far, which must be done before
+	// applying a resource operation (e.g. a file rename) that follows them.
+	applyEdits := func() error {
+		for b, e := range edits {
+			if err := v.applyProtocolTextEdits(b, e); err != nil {
+				return err
+			}
+		}
+		edits = make(map[*types.Buffer][]protocol.TextEdit)
+		return nil
+	}
+	if err := v.checkRenamesUnsaved(params.Edit.DocumentChanges); err != nil {
+		res.FailureReason = err.Error()
+		res.Applied = false
+		return res, nil
+	}
 	for _, dc := range params.Edit.DocumentChanges {
-		// TODO: protocol.DocumentChanges is a union that (currently) must have
-		// either TextDocumentEdit or RenameFile set. We should add support for
-		// renaming files as well.
 		if dc.TextDocumentEdit == nil {
-			return nil, fmt.Errorf("got unsupported workspace edit from gopls (e.g. file rename)")
+			err := applyEdits()
+			if err == nil {
+				err = v.applyResourceOperation(dc)
+			}
+			if err != nil {
+				res.FailureReason = err.Error()
+				res.Applied = false
+				return res, nil
+			}
+			continue
 		}
 		textDoc := dc.TextDocumentEdit.TextDocument
 
@@ -338,12 +498,9 @@ func (v *vimstate) applyWorkspaceEdit(params *protocol.ApplyWorkspaceEditParams)
 		edits[buf] = append(edits[buf], dc.TextDocumentEdit.Edits...)
 	}
 
-	for b, e := range edits {
-		if err := v.applyProtocolTextEdits(b, e); err != nil {
-			res.FailureReason = err.Error()
-			res.Applied = false
-			return res, nil
-		}
+	if err := applyEdits(); err != nil {
+		res.FailureReason = err.Error()
+		res.Applied = false
 	}
 	return res, nil
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/govim/govim"
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/command"
+	"github.com/govim/govim/cmd/govim/internal/types"
+	"golang.org/x/mod/modfile"
+)
+
+const quickfixVulncheckTitle = "govim vulncheck"
+
+// vulncheck runs govulncheck on the module of the current buffer, in the
+// background. The results are shown by handleVulncheck.
+func (v *vimstate) vulncheck(flags govim.CommandFlags, args ...string) error {
+	pattern := "./..."
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+	dir := v.workingDirectory
+	if b, _, err := v.bufCursorPos(); err == nil {
+		if root := v.workspaceRoot(b); root != "" {
+			dir = root
+		}
+	}
+
+	env := v.goCommandEnv()
+	if v.config.VulncheckDB != nil && *v.config.VulncheckDB != "" {
+		env = append(env, "GOVULNDB="+vulncheckDB(*v.config.VulncheckDB))
+	}
+
+	// Only the results of the most recent run are of interest
+	if v.cancelVulncheck != nil {
+		v.cancelVulncheck()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancelVulncheck = cancel
+
+	cmd := exec.CommandContext(ctx, v.goplspath, "vulncheck", pattern)
+	cmd.Dir = dir
+	cmd.Env = env
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	v.Logf("running govulncheck in %v: %v", dir, strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return fmt.Errorf("failed to run %v: %v", strings.Join(cmd.Args, " "), err)
+	}
+	v.ChannelExf("echom %q", "Running govulncheck on "+pattern)
+
+	v.tomb.Go(func() error {
+		var res command.VulncheckResult
+		err := cmd.Wait()
+		if err != nil {
+			err = fmt.Errorf("govulncheck failed: %v\n%s", err, bytes.TrimSpace(stderr.Bytes()))
+		} else if err = json.Unmarshal(stdout.Bytes(), &res); err != nil {
+			err = fmt.Errorf("failed to parse govulncheck output: %v", err)
+		}
+		v.govimplugin.Schedule(func(govim.Govim) error {
+			select {
+			case <-ctx.Done():
+				// Superseded by another run
+				return nil
+			default:
+			}
+			cancel()
+			if err != nil {
+				v.Logf("%v", err)
+				v.showMessage("ErrorMsg", err.Error())
+				return nil
+			}
+			return v.handleVulncheck(dir, res.Vuln)
+		})
+		return nil
+	})
+	return nil
+}
+
+// vulncheckDB returns the GOVULNDB value for the config.Config.VulncheckDB
+// value db. Bare absolute paths are taken to be local databases.
+func vulncheckDB(db string) string {
+	var res []string
+	for _, d := range strings.Split(db, ",") {
+		d = strings.TrimSpace(d)
+		if filepath.IsAbs(d) {
+			d = "file://" + filepath.ToSlash(d)
+		}
+		res = append(res, d)
+	}
+	return strings.Join(res, ",")
+}
+
+// handleVulncheck fills the quickfix list with the vulnerabilities vulns
+// found by running govulncheck in dir, and annotates go.mod buffers.
+func (v *vimstate) handleVulncheck(dir string, vulns []command.Vuln) error {
+	v.vulns = vulns
+
+	// must be non-nil
+	fixes := []quickfixEntry{}
+	gomod := filepath.Join(dir, "go.mod")
+	var requireLines map[string]int
+	if byts, err := os.ReadFile(gomod); err == nil {
+		requireLines = modRequireLines(gomod, byts)
+	}
+	for _, vuln := range vulns {
+		version := vuln.ModPath
+		if vuln.CurrentVersion != "" {
+			version += "@" + vuln.CurrentVersion
+		}
+		if vuln.FixedVersion != "" {
+			version += ", fixed in " + vuln.FixedVersion
+		}
+		if len(vuln.CallStacks) == 0 {
+			// The vulnerable code is not called
+			qf := quickfixEntry{
+				Text: fmt.Sprintf("%v: %v is required but the vulnerable code is not called (%v)", vuln.ID, vuln.ModPath, version),
+			}
+			if line, ok := requireLines[vuln.ModPath]; ok {
+				qf.Filename = v.relativeFilename(gomod)
+				qf.Lnum = line
+				qf.Col = 1
+			}
+			fixes = append(fixes, qf)
+			continue
+		}
+		for i, stack := range vuln.CallStacks {
+			if len(stack) == 0 {
+				continue
+			}
+			summary := vuln.Symbol
+			if i < len(vuln.CallStackSummaries) {
+				summary = vuln.CallStackSummaries[i]
+			}
+			qf := v.stackEntryToQuickfix(stack[0])
+			qf.Text = fmt.Sprintf("%v: %v (%v)", vuln.ID, summary, version)
+			fixes = append(fixes, qf)
+			for _, e := range stack[1:] {
+				qf := v.stackEntryToQuickfix(e)
+				qf.Text = "    " + e.Name
+				fixes = append(fixes, qf)
+			}
+		}
+	}
+
+	v.BatchStart()
+	v.BatchChannelCall("setqflist", fixes, "r")
+	v.BatchChannelCall("setqflist", []quickfixEntry{}, "r", qflistProps{Title: quickfixVulncheckTitle})
+	v.MustBatchEnd()
+	if len(fixes) == 0 {
+		v.ChannelEx(`echom "govulncheck found no vulnerabilities"`)
+	} else {
+		v.ChannelEx("copen")
+	}
+
+	for _, b := range v.buffers {
+		v.annotateVulnerableRequires(b)
+	}
+	return nil
+}
+
+// stackEntryToQuickfix returns a quickfix entry for the position of e.
+func (v *vimstate) stackEntryToQuickfix(e command.StackEntry) quickfixEntry {
+	if e.URI == "" {
+		return quickfixEntry{}
+	}
+	return quickfixEntry{
+		Filename: v.relativeFilename(e.URI.SpanURI().Filename()),
+		Lnum:     int(e.Pos.Line) + 1,
+		Col:      int(e.Pos.Character) + 1,
+	}
+}
+
+// relativeFilename returns fn relative to the working directory, if fn is
+// within it.
+func (v *vimstate) relativeFilename(fn string) string {
+	if rel, err := filepath.Rel(v.workingDirectory, fn); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return fn
+}
+
+// modRequireLines returns the line numbers of the require directives of the
+// go.mod file with name fn and contents byts, keyed by module path.
+func modRequireLines(fn string, byts []byte) map[string]int {
+	f, err := modfile.ParseLax(fn, byts, nil)
+	if err != nil {
+		return nil
+	}
+	res := make(map[string]int)
+	for _, r := range f.Require {
+		res[r.Mod.Path] = r.Syntax.Start.Line
+	}
+	return res
+}
+
+// annotateVulnerableRequires annotates the require lines in b, if it is a
+// go.mod file, with the vulnerabilities found in the required modules by the
+// last run of govulncheck.
+func (v *vimstate) annotateVulnerableRequires(b *types.Buffer) {
+	if !b.Loaded || filepath.Base(b.Name) != "go.mod" {
+		return
+	}
+	v.BatchStart()
+	defer v.BatchCancelIfNotEnded()
+	v.BatchChannelCall("prop_remove", struct {
+		Type  string `json:"type"`
+		BufNr int    `json:"bufnr"`
+		All   int    `json:"all"`
+	}{string(config.HighlightVulncheck), b.Num, 1})
+
+	byMod := make(map[string][]string)
+	for _, vuln := range v.vulns {
+		desc := vuln.ID
+		if vuln.FixedVersion != "" {
+			desc += " (fixed in " + vuln.FixedVersion + ")"
+		}
+		byMod[vuln.ModPath] = append(byMod[vuln.ModPath], desc)
+	}
+	lines := modRequireLines(b.Name, b.Contents())
+	var mods []string
+	for mod := range byMod {
+		if _, ok := lines[mod]; ok {
+			mods = append(mods, mod)
+		}
+	}
+	sort.Strings(mods)
+	for _, mod := range mods {
+		line := lines[mod]
+		l, err := b.Line(line)
+		if err != nil {
+			continue
+		}
+		descs := byMod[mod]
+		sort.Strings(descs)
+		text := "  vulnerable: " + strings.Join(dedupStrings(descs), ", ")
+		if v.hasVirtualText {
+			// Add the annotation as virtual text at the end of the line
+			col := len(l) + 1
+			v.BatchAssertChannelCall(assertPropAdd, "prop_add", line, col,
+				propAddTextDict{string(config.HighlightVulncheck), text, b.Num},
+			)
+		} else {
+			v.BatchAssertChannelCall(assertPropAdd, "prop_add", line, 1, propAddDict{
+				Type:    string(config.HighlightVulncheck),
+				ID:      types.VulncheckTextPropID,
+				EndLine: line,
+				EndCol:  len(l) + 1,
+				BufNr:   b.Num,
+			})
+		}
+	}
+	v.MustBatchEnd()
+}
+
+// dedupStrings removes adjacent duplicates from the sorted slice s.
+func dedupStrings(s []string) []string {
+	var res []string
+	for i, e := range s {
+		if i > 0 && s[i-1] == e {
+			continue
+		}
+		res = append(res, e)
+	}
+	return res
+}
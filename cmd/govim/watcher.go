@@ -5,19 +5,36 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/govim/govim"
 	"github.com/govim/govim/cmd/govim/internal/fswatcher"
+	"github.com/govim/govim/cmd/govim/internal/glob"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/span"
 	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
 )
 
-type modWatcher struct {
+// watchedFilesBatchDelay is how long we wait for further file system events
+// before sending those we have to gopls in a single
+// workspace/didChangeWatchedFiles notification.
+const watchedFilesBatchDelay = 100 * time.Millisecond
+
+// fileSystemWatcher is a file system watcher registered by gopls via
+// workspace/didChangeWatchedFiles.
+type fileSystemWatcher struct {
+	glob *glob.Glob
+
+	// kind is the protocol.WatchKind bitmask of the changes of interest
+	kind protocol.WatchKind
+}
+
+// dirWatcher watches a root directory, and the directories beneath it, for
+// file system events that might be of interest to gopls.
+type dirWatcher struct {
 	// We don't use the *vimstate type because we are operating outside of the Vim/vimstate
-	// "thread". Perhaps slightly inefficient that we query Vim to see whether a buffer is
-	// loaded or not, but that should be de minimums... and in any case this hack will soon
-	// disappear
+	// "thread".
 	*govimplugin
 
 	watcher *fswatcher.FSWatcher
@@ -26,39 +43,38 @@ type modWatcher struct {
 	root string
 }
 
-func (m *modWatcher) close() error { return m.watcher.Close() }
+func (d *dirWatcher) close() error { return d.watcher.Close() }
 
-// newWatcher returns a new watcher that will "watch" on the Go files in the
-// module identified by gomodpath
-func newModWatcher(plug *govimplugin, gomodpath string) (*modWatcher, error) {
+// newDirWatcher returns a new watcher that will "watch" on the files beneath
+// the directory root.
+func newDirWatcher(plug *govimplugin, root string) (*dirWatcher, error) {
 	infof := func(format string, args ...interface{}) {
 		plug.Logf("file watcher event: "+format, args...)
 	}
 
-	dirpath := filepath.Dir(gomodpath)
-	dir, err := os.Stat(dirpath)
+	dir, err := os.Stat(root)
 	if err != nil || !dir.IsDir() {
-		return nil, fmt.Errorf("could not resolve dir from go.mod path %v: %v", gomodpath, err)
+		return nil, fmt.Errorf("could not watch %v: not a directory", root)
 	}
 
-	w, err := fswatcher.New(dirpath, eventFilter(dirpath), infof, &plug.tomb)
+	w, err := fswatcher.New(root, eventFilter(root), infof, &plug.tomb)
 	if err != nil {
 		return nil, err
 	}
 
-	res := &modWatcher{
+	res := &dirWatcher{
 		govimplugin: plug,
 		watcher:     w,
-		root:        dirpath,
+		root:        root,
 	}
 
 	go res.watch()
 	return res, nil
 }
 
-func (m *modWatcher) watch() {
-	eventCh := m.watcher.Events()
-	errCh := m.watcher.Errors()
+func (d *dirWatcher) watch() {
+	eventCh := d.watcher.Events()
+	errCh := d.watcher.Errors()
 
 	for {
 		select {
@@ -68,12 +84,8 @@ func (m *modWatcher) watch() {
 				return
 			}
 
-			if !ofInterest(event.Path) {
-				continue
-			}
-
-			m.Enqueue(func(govim.Govim) error {
-				return m.vimstate.handleEvent(event)
+			d.Enqueue(func(govim.Govim) error {
+				return d.vimstate.handleEvent(event)
 			})
 
 		case err, ok := <-errCh:
@@ -82,7 +94,7 @@ func (m *modWatcher) watch() {
 				return
 			}
 			// TODO: handle this case better
-			m.Logf("***** file watcher error: %v", err)
+			d.Logf("***** file watcher error: %v", err)
 		}
 	}
 }
@@ -96,6 +108,11 @@ func (m *modWatcher) watch() {
 // and the module boundary described by https://golang.org/ref/mod#modules-overview:
 //
 //	> The module root directory is the directory that contains the go.mod file.
+//
+// gopls ignores such files in any case, and not watching such directories
+// saves us from watching the likes of .git. Whether events for the remaining
+// files are sent to gopls is determined by the file system watchers it has
+// registered.
 func eventFilter(root string) func(string) bool {
 	return func(path string) bool {
 		path = filepath.Clean(path)
@@ -139,16 +156,113 @@ func eventFilter(root string) func(string) bool {
 	}
 }
 
-func ofInterest(path string) bool {
-	return filepath.Ext(path) == ".go" || filepath.Base(path) == "go.mod" || filepath.Base(path) == "go.sum"
+// registerWatchedFiles handles the registration of file system watchers by
+// gopls, under the registration ID id.
+func (v *vimstate) registerWatchedFiles(id string, watchers []fileSystemWatcher) error {
+	if v.watchedFiles == nil {
+		v.watchedFiles = make(map[string][]fileSystemWatcher)
+	}
+	v.watchedFiles[id] = watchers
+	v.updateDirWatchers()
+	// Send any events that we have been holding on to until gopls told us
+	// what it is interested in
+	return v.flushFileEvents()
 }
 
-func (v *vimstate) handleEvent(event fswatcher.Event) error {
-	// We are handling a filesystem event... so the best we can do is log errors
-	errf := func(format string, args ...interface{}) {
-		v.Logf("**** handleEvent error: "+format, args...)
+// unregisterWatchedFiles handles gopls unregistering the file system watchers
+// it registered under the registration ID id.
+func (v *vimstate) unregisterWatchedFiles(id string) {
+	delete(v.watchedFiles, id)
+	v.updateDirWatchers()
+}
+
+// watchRoots returns the directories that need to be watched in order to
+// satisfy the file system watchers registered by gopls. Until gopls registers
+// any watchers, we watch the workspace folders.
+func (v *vimstate) watchRoots() []string {
+	var roots []string
+	relative := v.watchedFiles == nil
+	for _, watchers := range v.watchedFiles {
+		for _, w := range watchers {
+			if !w.glob.IsAbs() {
+				// Relative patterns match within the workspace folders
+				relative = true
+			} else if root := w.glob.Root(); root != "" {
+				roots = append(roots, filepath.FromSlash(root))
+			}
+		}
+	}
+	if relative {
+		v.workspaceFoldersLock.Lock()
+		roots = append(roots, v.workspaceFolders...)
+		v.workspaceFoldersLock.Unlock()
+	}
+
+	// Sorting ensures a root is considered before any roots beneath it. A root
+	// beneath another is redundant unless the watcher for the latter excludes
+	// it, e.g. because it is a different module.
+	sort.Strings(roots)
+	var res []string
+Roots:
+	for _, root := range roots {
+		if !filepath.IsAbs(root) {
+			continue
+		}
+		for _, prev := range res {
+			if root == prev {
+				continue Roots
+			}
+			if strings.HasPrefix(root, prev+string(os.PathSeparator)) && !eventFilter(prev)(root) {
+				continue Roots
+			}
+		}
+		res = append(res, root)
+	}
+	return res
+}
+
+// updateDirWatchers starts and stops watchers such that we watch the roots
+// returned by watchRoots.
+func (v *vimstate) updateDirWatchers() {
+	roots := v.watchRoots()
+	want := make(map[string]bool)
+	for _, root := range roots {
+		want[root] = true
+	}
+	for root, w := range v.dirWatchers {
+		if want[root] {
+			continue
+		}
+		if err := w.close(); err != nil {
+			v.Logf("failed to close file watcher for %v: %v", root, err)
+		}
+		delete(v.dirWatchers, root)
+	}
+	for _, root := range roots {
+		if _, ok := v.dirWatchers[root]; ok {
+			continue
+		}
+		w, err := newDirWatcher(v.govimplugin, root)
+		if err != nil {
+			v.Logf("failed to create file watcher for %v: %v", root, err)
+			continue
+		}
+		v.dirWatchers[root] = w
+	}
+}
+
+// closeDirWatchers stops all file watchers.
+func (g *govimplugin) closeDirWatchers() error {
+	for root, w := range g.dirWatchers {
+		if err := w.close(); err != nil {
+			return fmt.Errorf("failed to close file watcher for %v: %v", root, err)
+		}
+		delete(g.dirWatchers, root)
 	}
+	return nil
+}
 
+func (v *vimstate) handleEvent(event fswatcher.Event) error {
 	var changeType protocol.FileChangeType
 	switch event.Op {
 	case fswatcher.OpRemoved:
@@ -164,19 +278,129 @@ func (v *vimstate) handleEvent(event fswatcher.Event) error {
 	uri := span.URIFromPath(event.Path)
 	v.autoreadBuffer(uri)
 
+	v.pendingFileEvents = append(v.pendingFileEvents, protocol.FileEvent{
+		URI:  protocol.DocumentURI(uri),
+		Type: changeType,
+	})
+	if !v.fileEventsFlushScheduled {
+		v.fileEventsFlushScheduled = true
+		time.AfterFunc(watchedFilesBatchDelay, func() {
+			v.govimplugin.Enqueue(func(govim.Govim) error {
+				return v.flushFileEvents()
+			})
+		})
+	}
+	v.Logf("handleEvent: handled %v", event)
+	return nil
+}
+
+// flushFileEvents sends the pending file system events that are of interest
+// to gopls in a single workspace/didChangeWatchedFiles notification.
+func (v *vimstate) flushFileEvents() error {
+	v.fileEventsFlushScheduled = false
+	if v.watchedFiles == nil {
+		// gopls has yet to register its watchers. Hold on to the events
+		// until it does.
+		return nil
+	}
+	var changes []protocol.FileEvent
+	for _, e := range coalesceFileEvents(v.pendingFileEvents) {
+		if v.watchingFile(e.URI.SpanURI().Filename(), e.Type) {
+			changes = append(changes, e)
+		}
+	}
+	v.pendingFileEvents = nil
+	if len(changes) == 0 {
+		return nil
+	}
 	params := &protocol.DidChangeWatchedFilesParams{
-		Changes: []protocol.FileEvent{
-			{URI: protocol.DocumentURI(uri), Type: changeType},
-		},
+		Changes: changes,
 	}
-	err := v.server.DidChangeWatchedFiles(context.Background(), params)
-	if err != nil {
-		errf("failed to call server.DidChangeWatchedFiles: %v", err)
+	if err := v.server.DidChangeWatchedFiles(context.Background(), params); err != nil {
+		// We are handling filesystem events... so the best we can do is log errors
+		v.Logf("**** handleEvent error: failed to call server.DidChangeWatchedFiles: %v", err)
 	}
-	v.Logf("handleEvent: handled %v", event)
 	return nil
 }
 
+// watchingFile reports whether gopls has registered a file system watcher
+// for changes of type typ to the file path.
+func (v *vimstate) watchingFile(path string, typ protocol.FileChangeType) bool {
+	var kind protocol.WatchKind
+	switch typ {
+	case protocol.Created:
+		kind = protocol.WatchCreate
+	case protocol.Changed:
+		kind = protocol.WatchChange
+	case protocol.Deleted:
+		kind = protocol.WatchDelete
+	}
+	v.workspaceFoldersLock.Lock()
+	folders := append([]string(nil), v.workspaceFolders...)
+	v.workspaceFoldersLock.Unlock()
+	for _, watchers := range v.watchedFiles {
+		for _, w := range watchers {
+			// The kind defaults to all kinds of change
+			if w.kind != 0 && w.kind&kind == 0 {
+				continue
+			}
+			if w.glob.IsAbs() {
+				if w.glob.Match(filepath.ToSlash(path)) {
+					return true
+				}
+				continue
+			}
+			for _, folder := range folders {
+				rel, err := filepath.Rel(folder, path)
+				if err != nil || strings.HasPrefix(rel, "..") {
+					continue
+				}
+				if w.glob.Match(filepath.ToSlash(rel)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// coalesceFileEvents reduces events to a single event per file, which
+// reflects the net change to the file.
+func coalesceFileEvents(events []protocol.FileEvent) []protocol.FileEvent {
+	type change struct {
+		existed bool
+		exists  bool
+	}
+	var uris []protocol.DocumentURI
+	changes := make(map[protocol.DocumentURI]*change)
+	for _, e := range events {
+		c, ok := changes[e.URI]
+		if !ok {
+			c = &change{existed: e.Type != protocol.Created}
+			changes[e.URI] = c
+			uris = append(uris, e.URI)
+		}
+		c.exists = e.Type != protocol.Deleted
+	}
+	var res []protocol.FileEvent
+	for _, uri := range uris {
+		var typ protocol.FileChangeType
+		switch c := changes[uri]; {
+		case c.existed && c.exists:
+			typ = protocol.Changed
+		case c.existed:
+			typ = protocol.Deleted
+		case c.exists:
+			typ = protocol.Created
+		default:
+			// The file came and went
+			continue
+		}
+		res = append(res, protocol.FileEvent{URI: uri, Type: typ})
+	}
+	return res
+}
+
 func (v *vimstate) autoreadBuffer(uri span.URI) {
 	if v.config.ExperimentalAutoreadLoadedBuffers == nil || !*v.config.ExperimentalAutoreadLoadedBuffers {
 		return
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/govim/govim/cmd/govim/config"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/util"
+)
+
+// defaultOpenExternalWith returns the default value of
+// config.Config.OpenExternalWith for the current platform.
+func defaultOpenExternalWith() string {
+	if runtime.GOOS == "darwin" {
+		return "open"
+	}
+	return "xdg-open"
+}
+
+// showMessage shows message in a popup at the top of the screen, using the
+// highlight group hl. The popup closes when the cursor or mouse moves.
+func (v *vimstate) showMessage(hl, message string) {
+	opts := make(map[string]interface{})
+	opts["mousemoved"] = "any"
+	opts["moved"] = "any"
+	opts["padding"] = []int{0, 1, 0, 1}
+	opts["wrap"] = true
+	opts["border"] = []int{}
+	opts["highlight"] = hl
+	opts["line"] = 1
+	opts["close"] = "click"
+
+	v.ChannelCall("popup_create", strings.Split(message, "\n"), opts)
+}
+
+// showMessageRequest shows a popup menu of the actions of params, sending the
+// index of the action selected by the user (or -1 if the popup is closed
+// without a selection) to selected.
+func (v *vimstate) showMessageRequest(params *protocol.ShowMessageRequestParams, selected chan<- int) int {
+	items := make([]string, len(params.Actions))
+	for i, a := range params.Actions {
+		items[i] = a.Title
+	}
+	var hl string
+	switch params.Type {
+	case protocol.Error:
+		hl = "ErrorMsg"
+	case protocol.Warning:
+		hl = "WarningMsg"
+	}
+	opts := map[string]interface{}{
+		"title":    " " + strings.ReplaceAll(params.Message, "\n", " ") + " ",
+		"callback": "g:GOVIM" + config.FunctionPopupSelection,
+		"wrap":     true,
+	}
+	if hl != "" {
+		opts["borderhighlight"] = []string{hl}
+	}
+	popupID := v.ParseInt(v.ChannelCall("popup_menu", items, opts))
+	v.messageRequestPopups[popupID] = selected
+	return popupID
+}
+
+// messageRequestSelection handles the selection made in the popup for a
+// ShowMessageRequest, returning false if popupID is not such a popup.
+func (v *vimstate) messageRequestSelection(popupID, selection int) bool {
+	selected, ok := v.messageRequestPopups[popupID]
+	if !ok {
+		return false
+	}
+	delete(v.messageRequestPopups, popupID)
+	// selection numbers from 1; 0 = popup_close() called, -1 = ESC closed popup
+	if selection < 1 {
+		selection = 0
+	}
+	selected <- selection - 1
+	return true
+}
+
+// closeMessageRequest closes the popup for a ShowMessageRequest that is no
+// longer required, e.g. because gopls cancelled the request.
+func (v *vimstate) closeMessageRequest(popupID int) {
+	if _, ok := v.messageRequestPopups[popupID]; !ok {
+		return
+	}
+	// The popup callback forgets the popup, see messageRequestSelection. No
+	// one waits for the selection, which is buffered.
+	v.ChannelCall("popup_close", popupID)
+}
+
+// showDocument shows the document described by params, either in Vim or, for
+// non-file URIs or when requested, in an external program.
+func (v *vimstate) showDocument(params *protocol.ShowDocumentParams) error {
+	if params.External || !strings.HasPrefix(string(params.URI), "file://") {
+		return v.openExternal(string(params.URI))
+	}
+	vp := v.Viewport()
+	loc := protocol.Location{
+		URI:   protocol.DocumentURI(params.URI),
+		Range: params.Selection,
+	}
+	if err := v.loadLocation(nil, loc); err != nil {
+		return fmt.Errorf("failed to load %v: %v", params.URI, err)
+	}
+	if !params.TakeFocus {
+		v.ChannelCall("win_gotoid", vp.Current.WinID)
+		return nil
+	}
+	if params.Selection.Start == params.Selection.End {
+		return nil
+	}
+	b, ok := v.buffers[v.ParseInt(v.ChannelCall("bufnr", ""))]
+	if !ok {
+		return fmt.Errorf("failed to resolve buffer for %v", params.URI)
+	}
+	return v.selectRange(b, params.Selection)
+}
+
+// openExternal opens uri using the command configured by
+// config.Config.OpenExternalWith.
+func (v *vimstate) openExternal(uri string) error {
+	var open string
+	if v.config.OpenExternalWith != nil {
+		open = *v.config.OpenExternalWith
+	}
+	args, err := util.Split(open)
+	if err != nil {
+		return fmt.Errorf("invalid OpenExternalWith config %q: %v", open, err)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("cannot open %v: OpenExternalWith is empty", uri)
+	}
+	cmd := exec.Command(args[0], append(args[1:], uri)...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run %v: %v", strings.Join(cmd.Args, " "), err)
+	}
+	// Reap the process, which may well outlive the request
+	v.tomb.Go(func() error {
+		if err := cmd.Wait(); err != nil {
+			v.Logf("%v failed: %v", strings.Join(cmd.Args, " "), err)
+		}
+		return nil
+	})
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools/span"
+	"github.com/govim/govim/cmd/govim/internal/golang_org_x_tools_gopls/lsp/protocol"
+	"github.com/govim/govim/cmd/govim/internal/types"
+)
+
+// toWorkspaceFolder returns the workspace folder for the directory dir.
+func toWorkspaceFolder(dir string) protocol.WorkspaceFolder {
+	return protocol.WorkspaceFolder{
+		URI:  string(span.URIFromPath(dir)),
+		Name: filepath.Base(dir),
+	}
+}
+
+// workspaceFolderList returns the workspace folders currently known to
+// gopls.
+func (g *govimplugin) workspaceFolderList() []protocol.WorkspaceFolder {
+	g.workspaceFoldersLock.Lock()
+	defer g.workspaceFoldersLock.Unlock()
+	res := make([]protocol.WorkspaceFolder, len(g.workspaceFolders))
+	for i, dir := range g.workspaceFolders {
+		res[i] = toWorkspaceFolder(dir)
+	}
+	return res
+}
+
+// workspaceRoot returns the directory of the go.work or go.mod file that
+// applies to the file of b, or "" if there is none.
+func (v *vimstate) workspaceRoot(b *types.Buffer) string {
+	dir := filepath.Dir(b.Name)
+	if root, ok := v.workspaceRoots[dir]; ok {
+		return root
+	}
+	var root string
+	gomodspec, err := goModSpecPath(dir)
+	if err != nil {
+		v.Logf("failed to derive go.work/go.mod path for %v: %v", b.Name, err)
+	} else if gomodspec != "" && gomodspec != os.DevNull {
+		root = filepath.Dir(gomodspec)
+	}
+	v.workspaceRoots[dir] = root
+	return root
+}
+
+// addWorkspaceFolderFor adds a workspace folder for the module (or
+// workspace) of b, if it is not already a workspace folder. This must be done
+// before gopls is told about b, such that gopls loads b in the right context.
+func (v *vimstate) addWorkspaceFolderFor(b *types.Buffer) {
+	root := v.workspaceRoot(b)
+	if root == "" {
+		return
+	}
+	v.workspaceFoldersLock.Lock()
+	for _, dir := range v.workspaceFolders {
+		if dir == root {
+			v.workspaceFoldersLock.Unlock()
+			return
+		}
+	}
+	v.workspaceFolders = append(v.workspaceFolders, root)
+	v.workspaceFoldersLock.Unlock()
+
+	params := &protocol.DidChangeWorkspaceFoldersParams{
+		Event: protocol.WorkspaceFoldersChangeEvent{
+			Added: []protocol.WorkspaceFolder{toWorkspaceFolder(root)},
+		},
+	}
+	if err := v.server.DidChangeWorkspaceFolders(context.Background(), params); err != nil {
+		v.Logf("failed to add workspace folder %v: %v", root, err)
+	}
+	v.updateDirWatchers()
+}
+
+// setRootWorkspaceFolder makes root the initial workspace folder, e.g.
+// because the working directory has changed. The previous initial folder
+// remains a workspace folder whilst there are buffers for which it is the
+// workspace root.
+func (v *vimstate) setRootWorkspaceFolder(root string) {
+	v.workspaceFoldersLock.Lock()
+	if len(v.workspaceFolders) == 0 || v.workspaceFolders[0] == root {
+		v.workspaceFoldersLock.Unlock()
+		return
+	}
+	prev := v.workspaceFolders[0]
+	folders := []string{root}
+	added := true
+	for _, dir := range v.workspaceFolders[1:] {
+		if dir == root {
+			added = false
+			continue
+		}
+		folders = append(folders, dir)
+	}
+	v.workspaceFolders = append(folders, prev)
+	v.workspaceFoldersLock.Unlock()
+
+	if added {
+		params := &protocol.DidChangeWorkspaceFoldersParams{
+			Event: protocol.WorkspaceFoldersChangeEvent{
+				Added: []protocol.WorkspaceFolder{toWorkspaceFolder(root)},
+			},
+		}
+		if err := v.server.DidChangeWorkspaceFolders(context.Background(), params); err != nil {
+			v.Logf("failed to add workspace folder %v: %v", root, err)
+		}
+	}
+	v.updateDirWatchers()
+	v.removeUnusedWorkspaceFolders()
+}
+
+// removeUnusedWorkspaceFolders removes the workspace folders added by
+// addWorkspaceFolderFor for which there are no longer any buffers. The
+// initial workspace folder is never removed.
+func (v *vimstate) removeUnusedWorkspaceFolders() {
+	inUse := make(map[string]bool)
+	for _, b := range v.buffers {
+		if root, ok := v.workspaceRoots[filepath.Dir(b.Name)]; ok {
+			inUse[root] = true
+		}
+	}
+	var removed []protocol.WorkspaceFolder
+	v.workspaceFoldersLock.Lock()
+	if len(v.workspaceFolders) == 0 {
+		v.workspaceFoldersLock.Unlock()
+		return
+	}
+	folders := v.workspaceFolders[:1]
+	for _, dir := range v.workspaceFolders[1:] {
+		if inUse[dir] {
+			folders = append(folders, dir)
+			continue
+		}
+		removed = append(removed, toWorkspaceFolder(dir))
+	}
+	v.workspaceFolders = folders
+	v.workspaceFoldersLock.Unlock()
+
+	if len(removed) == 0 {
+		return
+	}
+	params := &protocol.DidChangeWorkspaceFoldersParams{
+		Event: protocol.WorkspaceFoldersChangeEvent{
+			Removed: removed,
+		},
+	}
+	if err := v.server.DidChangeWorkspaceFolders(context.Background(), params); err != nil {
+		v.Logf("failed to remove workspace folders: %v", err)
+	}
+	v.updateDirWatchers()
+}
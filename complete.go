@@ -26,8 +26,14 @@ type CompleteItem struct {
 	Word     string `json:"word"`
 	Info     string `json:"info"`
 	Menu     string `json:"menu"`
+	Kind     string `json:"kind"`
 	UserData string `json:"user_data"`
 	Dup      int    `json:"dup"`
+	Equal    int    `json:"equal,omitempty"`
+
+	// AbbrHlgroup is only supported by more recent versions of Vim, see
+	// complete-items
+	AbbrHlgroup string `json:"abbr_hlgroup,omitempty"`
 }
 
 type CompleteInfo struct {
@@ -108,4 +108,6 @@ const (
 	EventMenuPopup                         // MenuPopup
 	EventCompleteDone                      // CompleteDone
 	EventUser                              // User
+	EventWinScrolled                       // WinScrolled
+	EventCompleteChanged                   // CompleteChanged
 )
@@ -18,3 +18,19 @@ nnoremap <buffer> <silent> [[ :call GOVIMMotion("prev", "File.Decls.Pos()")<cr>
 nnoremap <buffer> <silent> [] :call GOVIMMotion("prev", "File.Decls.End()")<cr>
 nnoremap <buffer> <silent> ][ :call GOVIMMotion("next", "File.Decls.Pos()")<cr>
 nnoremap <buffer> <silent> ]] :call GOVIMMotion("next", "File.Decls.End()")<cr>
+
+" Expand/shrink selection
+xnoremap <buffer> <silent> + :<C-u>call GOVIMExpandSelection()<cr>
+xnoremap <buffer> <silent> - :<C-u>call GOVIMShrinkSelection()<cr>
+
+" Snippet placeholders, see CompletionSnippets
+inoremap <buffer> <silent> <expr> <C-j> get(b:, "govim_snippet", 0) ? "\<C-\>\<C-n>:call GOVIMSnippetNext()\<cr>" : "\<C-j>"
+inoremap <buffer> <silent> <expr> <C-k> get(b:, "govim_snippet", 0) ? "\<C-\>\<C-n>:call GOVIMSnippetPrev()\<cr>" : "\<C-k>"
+snoremap <buffer> <silent> <C-j> <C-\><C-n>:call GOVIMSnippetNext()<cr>
+snoremap <buffer> <silent> <C-k> <C-\><C-n>:call GOVIMSnippetPrev()<cr>
+
+" Cycle signatures, see SignatureHelpAuto. This relies on <Cmd>, which calls
+" the function without leaving insert mode and so closing the popup
+if has("patch-8.2.1978")
+  inoremap <buffer> <silent> <expr> <C-l> get(b:, "govim_signature_help", 0) ? "\<Cmd>call GOVIMSignatureHelpCycle()\<cr>" : "\<C-l>"
+endif
@@ -59,6 +59,17 @@ let s:userBusy = 0
 set ballooneval
 set balloonevalterm
 
+" :saveas gives the buffer and its alternate buffer each other's names, which
+" triggers BufFilePre and BufFilePost for each, nested. s:bufFileDepth counts
+" the renames in progress, such that govim can tell a :saveas, which copies the
+" file, from a :file, which moves it.
+let s:bufFileDepth = 0
+augroup govimBufFile
+  autocmd!
+  autocmd BufFilePre * let s:bufFileDepth += 1
+  autocmd BufFilePost * let s:bufFileDepth -= 1
+augroup END
+
 let s:waitingToDrain = 0
 let s:scheduleBacklog = []
 let s:activeGovimCalls = 0
@@ -212,6 +223,13 @@ function GOVIMPluginStatus(...)
   return s:govim_status
 endfunction
 
+" GOVIMFoldExpr returns the fold level of line lnum of the current buffer for
+" use in foldexpr. The levels are computed by govim as the buffer changes, so
+" this never needs to call govim.
+function GOVIMFoldExpr(lnum)
+  return get(get(b:, "govim_foldlevels", []), a:lnum-1, 0)
+endfunction
+
 function s:userBusy(busy)
   if s:userBusy != a:busy
     let s:userBusy = a:busy
@@ -481,6 +499,39 @@ function s:cursorPos()
         \ }
 endfunction
 
+" s:renameBuffer gives the buffer bufnr the file name name, once the file has
+" been moved to name on disk. A loaded buffer, which govim ensures has no
+" unsaved changes, is written to name such that it is no longer marked as not
+" edited. An unloaded buffer is replaced with a new (unloaded) buffer for name.
+" No autocommands are triggered; govim updates its own state.
+function s:renameBuffer(bufnr, name)
+  if !bufloaded(a:bufnr)
+    execute "noautocmd bwipeout" a:bufnr
+    execute "badd" fnameescape(a:name)
+    return
+  endif
+  let l:oldname = fnamemodify(bufname(a:bufnr), ":p")
+  let l:cmd = "noautocmd keepalt saveas! ".fnameescape(a:name)
+  let l:winid = bufwinid(a:bufnr)
+  if l:winid != -1
+    call win_execute(l:winid, l:cmd)
+  else
+    " The buffer is hidden, so temporarily show it in a new window
+    let l:curr = win_getid()
+    execute "noautocmd keepalt keepjumps sbuffer" a:bufnr
+    execute l:cmd
+    noautocmd hide
+    noautocmd call win_gotoid(l:curr)
+  endif
+  " :saveas leaves behind a buffer for the old name, which is of no use now
+  " that the file has moved
+  for l:b in getbufinfo()
+    if l:b.name ==# l:oldname && l:b.bufnr != a:bufnr
+      execute "noautocmd bwipeout" l:b.bufnr
+    endif
+  endfor
+endfunction
+
 function s:batchCall(calls)
   " calls is a [][]interface. Each call c in calls has the following structure:
   "
@@ -16,3 +16,24 @@ function! s:addbufname(key, val)
   return a:val
 endfunction
 
+" GOVIMTest_textprops returns the positions of text properties of type typ in
+" the current buffer as a list of [lnum, col]
+function! GOVIMTest_textprops(typ)
+  let l:props = prop_list(1, {'end_lnum': -1, 'types': [a:typ]})
+  return map(l:props, {_, p -> [p.lnum, p.col]})
+endfunction
+
+" GOVIMTest_screenline returns the text displayed on screen for line lnum of
+" the current window, including any virtual text, with leading and trailing
+" whitespace removed
+function! GOVIMTest_screenline(lnum)
+  redraw
+  let l:row = screenpos(win_getid(), a:lnum, 1).row
+  return trim(join(map(range(1, &columns), {_, c -> screenstring(l:row, c)}), ''))
+endfunction
+
+" GOVIMTest_testresults returns the lines of the test results buffer with
+" durations replaced by "(Xs)", for reproducible results
+function! GOVIMTest_testresults()
+  return map(getbufline('govim-test-results', 1, '$'), {_, l -> substitute(l, '(\d\+\.\d\+s)', '(Xs)', 'g')})
+endfunction